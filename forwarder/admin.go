@@ -0,0 +1,190 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// adminHandlers are the endpoints of the admin server that belong to the forwarders it serves
+type adminHandlers struct {
+	ready  http.Handler
+	pause  http.Handler
+	resume http.Handler
+	// lastErrors is nil when no recent failures are kept
+	lastErrors http.Handler
+}
+
+// forwarderAdminHandlers returns the admin endpoints of a single forwarder
+func forwarderAdminHandlers(pause *pauser, ready *readiness, recent *recentErrors) adminHandlers {
+	handlers := adminHandlers{
+		ready:  ready.handler(),
+		pause:  pause.handler(pause.pause),
+		resume: pause.handler(pause.resume),
+	}
+	if recent != nil {
+		handlers.lastErrors = recent.handler()
+	}
+	return handlers
+}
+
+// startAdminServer serves operational endpoints such as /metrics, /readyz, /info, the /pause and /resume controls
+// and optionally /lasterrors and /config on the configured address until the context is cancelled
+func startAdminServer(ctx context.Context, cfg *Config, handlers adminHandlers) {
+	addr := cfg.AdminAddr
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/readyz", handlers.ready)
+	mux.Handle("/info", infoHandler(cfg))
+	mux.Handle("/pause", handlers.pause)
+	mux.Handle("/resume", handlers.resume)
+	if handlers.lastErrors != nil {
+		mux.Handle("/lasterrors", handlers.lastErrors)
+	}
+	// The configuration reveals topology such as URLs and topics, so it is only served when asked for
+	if cfg.AdminExposeConfig {
+		mux.Handle("/config", configHandler(cfg))
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			cfg.log().Printf("Error shutting down admin server: %v", err)
+		}
+	}()
+
+	go func() {
+		cfg.log().Printf("Admin server listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			cfg.log().Printf("Admin server error: %v", err)
+		}
+	}()
+}
+
+// adminGroup is the --admin-addr server shared by the forwarders of a --config file without an adminAddr of
+// their own. It reports ready only while all of them are, pauses and resumes all of them or the one named by
+// the subscription query parameter, and merges their recent failures.
+type adminGroup struct {
+	// size is the number of forwarders sharing the server, which is not ready until all have joined
+	size int
+
+	mu      sync.Mutex
+	members []adminMember
+}
+
+// adminMember is the admin state of one forwarder of an adminGroup
+type adminMember struct {
+	subscription string
+	pause        *pauser
+	ready        *readiness
+	recent       *recentErrors
+}
+
+// join adds a forwarder's admin state once its Run has created it
+func (g *adminGroup) join(member adminMember) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.members = append(g.members, member)
+}
+
+// snapshot returns the forwarders that have joined so far
+func (g *adminGroup) snapshot() []adminMember {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return slices.Clone(g.members)
+}
+
+// handlers returns the admin endpoints aggregating every forwarder of the group
+func (g *adminGroup) handlers(lastErrors bool) adminHandlers {
+	handlers := adminHandlers{
+		ready:  http.HandlerFunc(g.serveReady),
+		pause:  g.control(func(p *pauser) { p.pause() }),
+		resume: g.control(func(p *pauser) { p.resume() }),
+	}
+	if lastErrors {
+		handlers.lastErrors = http.HandlerFunc(g.serveLastErrors)
+	}
+	return handlers
+}
+
+// serveReady serves 200 when every forwarder is ready and 503 naming those that are not otherwise
+func (g *adminGroup) serveReady(w http.ResponseWriter, _ *http.Request) {
+	members := g.snapshot()
+	var notReady []string
+	for _, member := range members {
+		if !member.ready.ready() {
+			notReady = append(notReady, member.subscription)
+		}
+	}
+	switch {
+	case len(members) < g.size:
+		http.Error(w, fmt.Sprintf("not ready: %d of %d forwarders started", len(members), g.size), http.StatusServiceUnavailable)
+	case len(notReady) > 0:
+		http.Error(w, "not ready: "+strings.Join(notReady, ", "), http.StatusServiceUnavailable)
+	default:
+		w.Write([]byte("ok"))
+	}
+}
+
+// control returns an endpoint applying action to the pauser of every forwarder on POST, or only to the one of
+// the subscription query parameter when it is given
+func (g *adminGroup) control(action func(*pauser)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		subscription := r.URL.Query().Get("subscription")
+		matched := false
+		for _, member := range g.snapshot() {
+			if subscription == "" || member.subscription == subscription {
+				action(member.pause)
+				matched = true
+			}
+		}
+		if !matched {
+			http.Error(w, fmt.Sprintf("no forwarder for subscription %q", subscription), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// serveLastErrors serves the recent failures of every forwarder as one JSON array, newest first
+func (g *adminGroup) serveLastErrors(w http.ResponseWriter, _ *http.Request) {
+	records := []failureRecord{}
+	for _, member := range g.snapshot() {
+		if member.recent == nil {
+			continue
+		}
+		for _, record := range member.recent.snapshot() {
+			record.Subscription = member.subscription
+			records = append(records, record)
+		}
+	}
+	slices.SortStableFunc(records, func(a, b failureRecord) int { return b.Time.Compare(a.Time) })
+	body, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,76 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// aimdLimiter bounds concurrent deliveries with a limit that adapts to the downstream: it is halved when the
+// downstream signals overload with a 429 or 503 and grows by one after a full limit of consecutive successes
+type aimdLimiter struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	max       int
+	active    int
+	successes int
+}
+
+// newAIMDLimiter returns a limiter starting at max when --auto-concurrency is set, or nil otherwise
+func newAIMDLimiter(cfg *Config) *aimdLimiter {
+	if !cfg.AutoConcurrency {
+		return nil
+	}
+	if cfg.concurrencyLimiter != nil {
+		return cfg.concurrencyLimiter
+	}
+	a := &aimdLimiter{limit: cfg.AutoConcurrencyMax, max: cfg.AutoConcurrencyMax}
+	a.cond = sync.NewCond(&a.mu)
+	concurrencyLimit.Set(float64(a.limit))
+	return a
+}
+
+// acquire waits until fewer deliveries than the current limit are active
+func (a *aimdLimiter) acquire(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.cond.Broadcast()
+	})
+	defer stop()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for a.active >= a.limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		a.cond.Wait()
+	}
+	a.active++
+	return nil
+}
+
+// release ends a delivery and adjusts the limit based on its result
+func (a *aimdLimiter) release(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.active--
+
+	var postErr *PostError
+	switch {
+	case errors.As(err, &postErr) && (postErr.StatusCode == http.StatusTooManyRequests || postErr.StatusCode == http.StatusServiceUnavailable):
+		a.limit = max(a.limit/2, 1)
+		a.successes = 0
+	case err == nil:
+		a.successes++
+		if a.successes >= a.limit && a.limit < a.max {
+			a.limit++
+			a.successes = 0
+		}
+	}
+	concurrencyLimit.Set(float64(a.limit))
+	a.cond.Broadcast()
+}
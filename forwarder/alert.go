@@ -0,0 +1,104 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// alertPayload is the JSON body POSTed to the alert webhook
+type alertPayload struct {
+	Status              string `json:"status"`
+	Subscription        string `json:"subscription"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	LastError           string `json:"lastError,omitempty"`
+	Timestamp           string `json:"timestamp"`
+}
+
+// alerter fires a single alert webhook once consecutive delivery failures reach a threshold and a single
+// recovery alert when deliveries succeed again, so the endpoint is not notified per message
+type alerter struct {
+	url          string
+	threshold    int
+	subscription string
+	logger       *log.Logger
+
+	mu          sync.Mutex
+	consecutive int
+	alerting    bool
+}
+
+// newAlerter returns an alerter for the configured webhook, or nil when no webhook is configured
+func newAlerter(cfg *Config) *alerter {
+	if cfg.AlertWebhook == "" {
+		return nil
+	}
+	return &alerter{
+		url:          cfg.AlertWebhook,
+		threshold:    cfg.AlertFailureThreshold,
+		subscription: fmt.Sprintf("projects/%s/subscriptions/%s", cfg.Project, cfg.Subscription),
+		logger:       cfg.log(),
+	}
+}
+
+// failure records a failed delivery and fires the failing alert when the threshold is first reached
+func (a *alerter) failure(err error) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.consecutive++
+	fire := !a.alerting && a.consecutive >= a.threshold
+	if fire {
+		a.alerting = true
+	}
+	consecutive := a.consecutive
+	a.mu.Unlock()
+
+	if fire {
+		go a.send(alertPayload{Status: "failing", ConsecutiveFailures: consecutive, LastError: err.Error()})
+	}
+}
+
+// success records a successful delivery and fires the recovery alert if the failing alert had fired
+func (a *alerter) success() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	fire := a.alerting
+	consecutive := a.consecutive
+	a.alerting = false
+	a.consecutive = 0
+	a.mu.Unlock()
+
+	if fire {
+		go a.send(alertPayload{Status: "recovered", ConsecutiveFailures: consecutive})
+	}
+}
+
+// send POSTs the alert to the webhook, failures are only logged since alerting is best-effort
+func (a *alerter) send(payload alertPayload) {
+	payload.Subscription = a.subscription
+	payload.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		a.logger.Printf("Error marshaling %s alert: %v", payload.Status, err)
+		return
+	}
+
+	client := &http.Client{
+		Timeout: defaultHTTPTimeout,
+	}
+	resp, err := client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		a.logger.Printf("Error sending %s alert: %v", payload.Status, err)
+		return
+	}
+	resp.Body.Close()
+	a.logger.Printf("Sent %s alert after %d consecutive failures. HTTP Status: %s", payload.Status, payload.ConsecutiveFailures, resp.Status)
+}
@@ -0,0 +1,192 @@
+package forwarder
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// attemptTrackerMaxEntries bounds the memory used to count delivery attempts
+	attemptTrackerMaxEntries = 100000
+	// attemptTrackerTTL forgets a message that has not failed again within this long
+	attemptTrackerTTL = time.Hour
+	// attemptStateSaveInterval is how often changed counts are written to the --state-file
+	attemptStateSaveInterval = 10 * time.Second
+)
+
+// attemptEntry is the failed delivery count for a message and when it last failed
+type attemptEntry struct {
+	id       string
+	count    int
+	lastSeen time.Time
+}
+
+// attemptState is the --state-file form of a count
+type attemptState struct {
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// attemptTracker approximates redelivery counts in memory for subscriptions without a server-side dead-letter
+// policy, where DeliveryAttempt is not populated. Counts are best-effort: they reset on restart unless saved
+// to a --state-file, and are not shared between replicas.
+type attemptTracker struct {
+	mu     sync.Mutex
+	counts map[string]*list.Element
+	// order holds the *attemptEntry values from the most to the least recently failed, so the entry to evict
+	// is always at the back
+	order *list.List
+	path  string
+	dirty bool
+	// saveMu is held across writing and renaming the state file, so the periodic and final saves never
+	// interleave or write an older snapshot over a newer one
+	saveMu sync.Mutex
+	logger *log.Logger
+}
+
+// newAttemptTracker returns a tracker when --max-delivery-attempts is set, or nil otherwise. Counts saved to the
+// state file by a previous run are loaded, and a missing or unreadable file starts with no counts.
+func newAttemptTracker(cfg *Config) *attemptTracker {
+	if cfg.MaxDeliveryAttempts <= 0 {
+		return nil
+	}
+	a := &attemptTracker{counts: make(map[string]*list.Element), order: list.New(), path: cfg.StateFile, logger: cfg.log()}
+	if a.path != "" {
+		a.load()
+	}
+	return a
+}
+
+// load reads the counts from the state file, skipping those that have expired since they were saved
+func (a *attemptTracker) load() {
+	data, err := os.ReadFile(a.path)
+	if os.IsNotExist(err) {
+		return
+	}
+	var state map[string]attemptState
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil {
+		a.logger.Printf("Warning: ignoring unreadable state file %s: %v", a.path, err)
+		return
+	}
+	// Keep the most recent failures when the file holds more than fit, in the order they failed
+	ids := slices.SortedFunc(maps.Keys(state), func(x, y string) int { return state[y].LastSeen.Compare(state[x].LastSeen) })
+	now := time.Now()
+	for _, id := range ids {
+		entry := state[id]
+		if now.Sub(entry.LastSeen) <= attemptTrackerTTL && len(a.counts) < attemptTrackerMaxEntries {
+			a.counts[id] = a.order.PushBack(&attemptEntry{id: id, count: entry.Count, lastSeen: entry.LastSeen})
+		}
+	}
+	a.logger.Printf("Loaded %d delivery attempt counts from %s", len(a.counts), a.path)
+}
+
+// persist drops expired counts and saves changed ones to the state file every interval until the context is
+// cancelled
+func (a *attemptTracker) persist(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.sweep()
+			a.save()
+		}
+	}
+}
+
+// save writes the counts to the state file when they changed since the last save, replacing it atomically so
+// a crash mid-write leaves the previous state
+func (a *attemptTracker) save() {
+	a.saveMu.Lock()
+	defer a.saveMu.Unlock()
+
+	a.mu.Lock()
+	if !a.dirty {
+		a.mu.Unlock()
+		return
+	}
+	state := make(map[string]attemptState, len(a.counts))
+	for e := a.order.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*attemptEntry)
+		state[entry.id] = attemptState{Count: entry.count, LastSeen: entry.lastSeen}
+	}
+	a.dirty = false
+	a.mu.Unlock()
+
+	data, err := json.Marshal(state)
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(a.path), "."+filepath.Base(a.path)+".tmp")
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, a.path)
+		}
+	}
+	if err != nil {
+		a.logger.Printf("Error saving state file %s: %v", a.path, err)
+		a.mu.Lock()
+		a.dirty = true
+		a.mu.Unlock()
+	}
+}
+
+// failure records a failed delivery of the message and returns the number of failed deliveries so far. A new
+// message evicts the least recently failed one when the tracker is full, which keeps each failure O(1).
+func (a *attemptTracker) failure(id string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	a.dirty = true
+	if e, ok := a.counts[id]; ok {
+		entry := e.Value.(*attemptEntry)
+		if now.Sub(entry.lastSeen) > attemptTrackerTTL {
+			entry.count = 0
+		}
+		entry.count++
+		entry.lastSeen = now
+		a.order.MoveToFront(e)
+		return entry.count
+	}
+	if a.order.Len() >= attemptTrackerMaxEntries {
+		oldest := a.order.Back()
+		delete(a.counts, oldest.Value.(*attemptEntry).id)
+		a.order.Remove(oldest)
+	}
+	a.counts[id] = a.order.PushFront(&attemptEntry{id: id, count: 1, lastSeen: now})
+	return 1
+}
+
+// sweep removes the entries that have not failed again within the TTL, which are all at the back
+func (a *attemptTracker) sweep() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for e := a.order.Back(); e != nil && now.Sub(e.Value.(*attemptEntry).lastSeen) > attemptTrackerTTL; e = a.order.Back() {
+		delete(a.counts, e.Value.(*attemptEntry).id)
+		a.order.Remove(e)
+		a.dirty = true
+	}
+}
+
+// forget removes the count for a message that no longer needs tracking
+func (a *attemptTracker) forget(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if e, ok := a.counts[id]; ok {
+		delete(a.counts, id)
+		a.order.Remove(e)
+		a.dirty = true
+	}
+}
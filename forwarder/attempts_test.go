@@ -0,0 +1,65 @@
+package forwarder
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAttemptTrackerConcurrentSavesKeepLatestState(t *testing.T) {
+	cfg := &Config{MaxDeliveryAttempts: 5, StateFile: filepath.Join(t.TempDir(), "state.json")}
+	a := newAttemptTracker(cfg)
+
+	// The periodic save races the final one at shutdown
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.failure(fmt.Sprintf("message-%d", i))
+			a.save()
+		}()
+	}
+	wg.Wait()
+	a.save()
+
+	loaded := newAttemptTracker(cfg)
+	if len(loaded.counts) != 20 {
+		t.Errorf("state file holds %d counts, want all 20", len(loaded.counts))
+	}
+}
+
+func TestAttemptTrackerEvictsLeastRecentlyFailed(t *testing.T) {
+	a := newAttemptTracker(&Config{MaxDeliveryAttempts: 5})
+	for i := range attemptTrackerMaxEntries {
+		a.failure(fmt.Sprintf("message-%d", i))
+	}
+	// The oldest failure fails again, so the next one in line is evicted instead
+	a.failure("message-0")
+	a.failure("new")
+
+	if len(a.counts) != attemptTrackerMaxEntries {
+		t.Errorf("tracker holds %d counts, want %d", len(a.counts), attemptTrackerMaxEntries)
+	}
+	if _, ok := a.counts["message-1"]; ok {
+		t.Error("message-1 was kept, want the least recently failed message evicted")
+	}
+	if got := a.failure("message-0"); got != 3 {
+		t.Errorf("message-0 count = %d, want 3", got)
+	}
+}
+
+func TestAttemptTrackerSweepDropsExpired(t *testing.T) {
+	a := newAttemptTracker(&Config{MaxDeliveryAttempts: 5})
+	a.failure("old")
+	a.failure("recent")
+	a.counts["old"].Value.(*attemptEntry).lastSeen = time.Now().Add(-2 * attemptTrackerTTL)
+	a.order.MoveToBack(a.counts["old"])
+
+	a.sweep()
+	if _, ok := a.counts["old"]; ok || len(a.counts) != 1 {
+		t.Errorf("tracker holds %d counts after the sweep, want only the recent one", len(a.counts))
+	}
+}
@@ -0,0 +1,71 @@
+package forwarder
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// normalizeAttributeKeys returns the attributes with each key converted to the configured case. When several
+// keys normalize to the same one, the value of the key that sorts last wins so the result is deterministic.
+func normalizeAttributeKeys(attributes map[string]string, mode string, logger *log.Logger) map[string]string {
+	if mode == "" || len(attributes) == 0 {
+		return attributes
+	}
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	normalized := make(map[string]string, len(attributes))
+	sources := make(map[string]string, len(attributes))
+	for _, key := range keys {
+		name := normalizeKey(key, mode)
+		if previous, ok := sources[name]; ok {
+			logger.Printf("Attribute keys %q and %q both normalize to %q, keeping the value of %q", previous, key, name, key)
+		}
+		normalized[name] = attributes[key]
+		sources[name] = key
+	}
+	return normalized
+}
+
+// normalizeKey converts a key to lower, upper, kebab or snake case. Kebab and snake case split words at
+// dashes, underscores, spaces, dots and lower to upper case transitions, so TenantId, tenant_id and
+// Tenant-Id all become tenant-id.
+func normalizeKey(key, mode string) string {
+	switch mode {
+	case "lower":
+		return strings.ToLower(key)
+	case "upper":
+		return strings.ToUpper(key)
+	}
+	separator := "-"
+	if mode == "snake" {
+		separator = "_"
+	}
+	var words []string
+	var word []rune
+	previous := rune(0)
+	for _, r := range key {
+		switch {
+		case r == '-' || r == '_' || r == ' ' || r == '.':
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = nil
+			}
+		case unicode.IsUpper(r) && (unicode.IsLower(previous) || unicode.IsDigit(previous)):
+			words = append(words, string(word))
+			word = []rune{unicode.ToLower(r)}
+		default:
+			word = append(word, unicode.ToLower(r))
+		}
+		previous = r
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return strings.Join(words, separator)
+}
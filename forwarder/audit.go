@@ -0,0 +1,127 @@
+package forwarder
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditRecord is one forward attempt in the audit log
+type auditRecord struct {
+	Timestamp    string `json:"timestamp"`
+	MessageID    string `json:"messageId"`
+	Subscription string `json:"subscription"`
+	Target       string `json:"target"`
+	Status       string `json:"status"`
+	StatusCode   int    `json:"statusCode,omitempty"`
+	Error        string `json:"error,omitempty"`
+	// PrevHash is the SHA-256 of the previous line, chaining the records so edits and deletions are evident
+	PrevHash string `json:"prevHash"`
+}
+
+// auditLog appends a hash-chained JSON record of every forward attempt to a file, separate from the
+// operational logs
+type auditLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	prevHash string
+}
+
+// openAuditLog opens the audit log for appending, continuing the hash chain from its last record, or
+// returns nil when no audit log is configured
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	prevHash, err := lastLineHash(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	return &auditLog{file: file, prevHash: prevHash}, nil
+}
+
+// lastLineHash returns the hash of the last line of an existing audit log, or an empty hash for a new one
+func lastLineHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var last []byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		last = append(last[:0], scanner.Bytes()...)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+	if len(last) == 0 {
+		return "", nil
+	}
+	sum := sha256.Sum256(last)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// record writes and syncs the record of a forward attempt, whether it succeeded or failed
+func (a *auditLog) record(payload *PubSubMessage, target string, err error) error {
+	if a == nil {
+		return nil
+	}
+
+	rec := auditRecord{
+		Timestamp:    time.Now().UTC().Format(time.RFC3339Nano),
+		MessageID:    payload.Message.MessageID,
+		Subscription: payload.Subscription,
+		Target:       target,
+		Status:       "success",
+	}
+	if err != nil {
+		rec.Status = "failure"
+		rec.Error = err.Error()
+		var postErr *PostError
+		if errors.As(err, &postErr) {
+			rec.StatusCode = postErr.StatusCode
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rec.PrevHash = a.prevHash
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	sum := sha256.Sum256(line)
+	a.prevHash = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// Close closes the audit log file
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
@@ -0,0 +1,96 @@
+package forwarder
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Strategies for choosing the load balanced URL of each message
+const (
+	balanceWeighted   = "weighted"
+	balanceRoundRobin = "round-robin"
+)
+
+// BalanceTarget is one of the equivalent downstream URLs the HTTP sink spreads messages across
+type BalanceTarget struct {
+	URL    string
+	Weight int
+}
+
+// ParseBalanceTarget parses a target of the form url or url=weight, where the weight is a positive integer
+// and defaults to 1
+func ParseBalanceTarget(value string) (BalanceTarget, error) {
+	target := BalanceTarget{URL: value, Weight: 1}
+	if i := strings.LastIndexByte(value, '='); i >= 0 {
+		if weight, err := strconv.Atoi(value[i+1:]); err == nil {
+			if weight < 1 {
+				return BalanceTarget{}, fmt.Errorf("invalid balance URL %q: weight must be at least 1", value)
+			}
+			target = BalanceTarget{URL: value[:i], Weight: weight}
+		}
+	}
+	parsed, err := url.Parse(target.URL)
+	if err != nil {
+		return BalanceTarget{}, fmt.Errorf("invalid balance URL %q: %w", value, err)
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return BalanceTarget{}, fmt.Errorf("invalid balance URL %q: must include a scheme and host", value)
+	}
+	return target, nil
+}
+
+// balancer chooses the URL of each message from the targets in proportion to their weights
+type balancer struct {
+	targets    []BalanceTarget
+	roundRobin bool
+	total      int
+
+	mu      sync.Mutex
+	current []int
+}
+
+// newBalancer returns a balancer over the configured targets, or nil when none are configured
+func newBalancer(cfg *Config) *balancer {
+	if len(cfg.BalanceTargets) == 0 {
+		return nil
+	}
+	b := &balancer{
+		targets:    cfg.BalanceTargets,
+		roundRobin: cfg.BalanceStrategy == balanceRoundRobin,
+		current:    make([]int, len(cfg.BalanceTargets)),
+	}
+	for _, target := range b.targets {
+		b.total += target.Weight
+	}
+	return b
+}
+
+// next returns the URL for the next message. Weighted choice picks at random, while round-robin uses smooth
+// weighted round-robin so a heavier target's turns are interleaved with the others rather than consecutive.
+func (b *balancer) next() string {
+	if !b.roundRobin {
+		pick := rand.IntN(b.total)
+		for _, target := range b.targets {
+			if pick < target.Weight {
+				return target.URL
+			}
+			pick -= target.Weight
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	best := 0
+	for i, target := range b.targets {
+		b.current[i] += target.Weight
+		if b.current[i] > b.current[best] {
+			best = i
+		}
+	}
+	b.current[best] -= b.total
+	return b.targets[best].URL
+}
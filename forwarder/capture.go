@@ -0,0 +1,115 @@
+package forwarder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// captureRecord is one received message in the capture file, holding what is needed to replay it
+type captureRecord struct {
+	MessageID   string            `json:"messageId"`
+	PublishTime string            `json:"publishTime"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	// Data is the message data as received, base64 encoded
+	Data []byte `json:"data"`
+}
+
+// captureLog appends every received message to a file as a JSON line, for replaying with --replay
+type captureLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	logger *log.Logger
+}
+
+// openCaptureLog opens the capture file for appending, or returns nil when capturing is not configured
+func openCaptureLog(path string, logger *log.Logger) (*captureLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture file %s: %w", path, err)
+	}
+	return &captureLog{file: file, logger: logger}, nil
+}
+
+// record appends the message as received, before any filtering or transformation. Capturing is best-effort,
+// so a failure is logged without affecting delivery.
+func (c *captureLog) record(msg *pubsub.Message) {
+	if c == nil {
+		return
+	}
+	line, err := json.Marshal(captureRecord{
+		MessageID:   msg.ID,
+		PublishTime: msg.PublishTime.UTC().Format(time.RFC3339Nano),
+		OrderingKey: msg.OrderingKey,
+		Attributes:  msg.Attributes,
+		Data:        msg.Data,
+	})
+	if err != nil {
+		c.logger.Printf("Failed to capture message ID %s: %v", msg.ID, err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.file.Write(append(line, '\n')); err != nil {
+		c.logger.Printf("Failed to capture message ID %s: %v", msg.ID, err)
+	}
+}
+
+// Close closes the capture file
+func (c *captureLog) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// readCapture calls fn with each message of a capture file in the order it was received
+func readCapture(path string, fn func(*pubsub.Message) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// A line holds a whole message, which Pub/Sub allows to be up to 10 MB before base64 encoding
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec captureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("failed to parse line %d of capture file %s: %w", line, path, err)
+		}
+		publishTime, err := time.Parse(time.RFC3339Nano, rec.PublishTime)
+		if err != nil {
+			return fmt.Errorf("invalid publishTime on line %d of capture file %s: %w", line, path, err)
+		}
+		msg := &pubsub.Message{
+			ID:          rec.MessageID,
+			Data:        rec.Data,
+			Attributes:  rec.Attributes,
+			PublishTime: publishTime,
+			OrderingKey: rec.OrderingKey,
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read capture file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package forwarder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressBody encodes the request body with the configured algorithm, which is also its Content-Encoding
+func compressBody(body []byte, algorithm string) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch algorithm {
+	case "gzip":
+		writer = gzip.NewWriter(&buf)
+	case "deflate":
+		// The deflate content coding is the zlib format rather than a raw deflate stream
+		writer = zlib.NewWriter(&buf)
+	case "br":
+		writer = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", algorithm)
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		return nil, fmt.Errorf("failed to compress body with %s: %w", algorithm, err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress body with %s: %w", algorithm, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,43 @@
+package forwarder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompressBodyRoundTrip(t *testing.T) {
+	readers := map[string]func(io.Reader) (io.Reader, error){
+		"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+		"br":      func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	body := bytes.Repeat([]byte(`{"message":{"data":"aGVsbG8="}}`), 100)
+	for algorithm, newReader := range readers {
+		t.Run(algorithm, func(t *testing.T) {
+			compressed, err := compressBody(body, algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, err := newReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("%s round trip changed the body", algorithm)
+			}
+		})
+	}
+
+	if _, err := compressBody(body, "zstd"); err == nil {
+		t.Error("compressBody() accepted an unsupported algorithm")
+	}
+}
@@ -0,0 +1,141 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// redacted replaces secret values in the configuration served at /config
+const redacted = "[REDACTED]"
+
+// secretFieldWords mark configuration fields and sink options whose values are secrets
+var secretFieldWords = []string{
+	"secret", "token", "password", "connectionstring", "webhook", "apikey", "hmackey", "signingkey", "accesskey",
+}
+
+// isSecretName reports whether a field or option name suggests a secret value
+func isSecretName(name string) bool {
+	lower := strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(name))
+	for _, word := range secretFieldWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL removes a password and query parameter values from a URL, leaving other strings untouched
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return value
+	}
+	if u.RawQuery != "" {
+		params := make([]string, 0)
+		for key := range u.Query() {
+			params = append(params, url.QueryEscape(key)+"="+redacted)
+		}
+		sort.Strings(params)
+		u.RawQuery = strings.Join(params, "&")
+	}
+	return u.Redacted()
+}
+
+// redactValue converts a configuration value for JSON output, redacting the whole value when secret is set
+func redactValue(value reflect.Value, secret bool) any {
+	if secret {
+		if value.IsZero() {
+			return value.Interface()
+		}
+		return redacted
+	}
+	switch v := value.Interface().(type) {
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339Nano)
+	case *url.URL:
+		if v == nil {
+			return ""
+		}
+		return redactURL(v.String())
+	case string:
+		return redactURL(v)
+	case []string:
+		out := make([]string, len(v))
+		for i, item := range v {
+			out[i] = redactURL(item)
+		}
+		return out
+	case []Header:
+		// Header values commonly carry credentials such as Authorization, so only the names are shown
+		out := make([]map[string]string, len(v))
+		for i, header := range v {
+			out[i] = map[string]string{"name": header.Name, "value": redacted}
+		}
+		return out
+	case []Route:
+		out := make([]map[string]string, len(v))
+		for i, route := range v {
+			out[i] = map[string]string{"attribute": route.Attribute, "value": route.Value, "url": redactURL(route.URL)}
+		}
+		return out
+	case []SinkSpec:
+		out := make([]map[string]any, len(v))
+		for i, spec := range v {
+			options := make(map[string]string, len(spec.Options))
+			for key, option := range spec.Options {
+				if isSecretName(key) {
+					options[key] = redacted
+				} else {
+					options[key] = redactURL(option)
+				}
+			}
+			out[i] = map[string]any{
+				"type":     spec.Type,
+				"options":  options,
+				"optional": spec.Optional,
+				"timeout":  spec.Timeout.String(),
+				"retries":  spec.Retries,
+				"backoff":  spec.Backoff.String(),
+			}
+		}
+		return out
+	}
+	return value.Interface()
+}
+
+// redactedConfig returns the effective configuration keyed by field name with secrets redacted
+func redactedConfig(cfg *Config) map[string]any {
+	out := make(map[string]any)
+	value := reflect.ValueOf(cfg).Elem()
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		out[field.Name] = redactValue(value.Field(i), isSecretName(field.Name))
+	}
+	return out
+}
+
+// configHandler serves the redacted effective configuration as JSON
+func configHandler(cfg *Config) http.Handler {
+	body, err := json.MarshalIndent(redactedConfig(cfg), "", "  ")
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
@@ -0,0 +1,166 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// CorrelationSource locates a value of a message, such as its correlation ID or routing key, in an attribute or
+// at a JSONPath into its data
+type CorrelationSource struct {
+	// Attribute is the attribute holding the ID, empty when the ID is read from the data
+	Attribute string
+	// Path is the sequence of object keys and array indexes leading to the ID in the data
+	Path []any
+
+	expression string
+}
+
+// String returns the expression the source was parsed from
+func (s CorrelationSource) String() string {
+	return s.expression
+}
+
+// ParseCorrelationSource parses a source of the form attribute:name or jsonpath:$.path, where the path is a
+// sequence of .key and [index] steps such as $.order.items[0].id
+func ParseCorrelationSource(value string) (CorrelationSource, error) {
+	return parseSource(value, "correlation ID source")
+}
+
+// ParseKeySource parses a routing key source of the form orderingKey, attribute:name or jsonpath:$.path. The
+// orderingKey source is the zero CorrelationSource, which extracts nothing so the ordering key is used.
+func ParseKeySource(value string) (CorrelationSource, error) {
+	if value == "orderingKey" {
+		return CorrelationSource{expression: value}, nil
+	}
+	return parseSource(value, "key source")
+}
+
+// parseSource parses an attribute:name or jsonpath:$.path source, naming what it is for in errors
+func parseSource(value, what string) (CorrelationSource, error) {
+	kind, expression, _ := strings.Cut(value, ":")
+	source := CorrelationSource{expression: value}
+	switch kind {
+	case "attribute":
+		if expression == "" {
+			return CorrelationSource{}, fmt.Errorf("invalid %s %q: missing attribute name", what, value)
+		}
+		source.Attribute = expression
+		return source, nil
+	case "jsonpath":
+		path, err := parseJSONPath(expression)
+		if err != nil {
+			return CorrelationSource{}, fmt.Errorf("invalid %s %q: %w", what, value, err)
+		}
+		source.Path = path
+		return source, nil
+	}
+	return CorrelationSource{}, fmt.Errorf("invalid %s %q: must be attribute:name or jsonpath:$.path", what, value)
+}
+
+// parseJSONPath parses the supported JSONPath subset into object keys and array indexes
+func parseJSONPath(expression string) ([]any, error) {
+	rest, ok := strings.CutPrefix(expression, "$")
+	if !ok {
+		return nil, fmt.Errorf("path must start with $")
+	}
+	var path []any
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("empty key in path %q", expression)
+			}
+			path = append(path, key)
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [ in path %q", expression)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid array index %q in path %q", rest[1:end], expression)
+			}
+			path = append(path, index)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in path %q", rest[0], expression)
+		}
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("path %q selects no field", expression)
+	}
+	return path, nil
+}
+
+// extract returns the correlation ID of the message, or false when the attribute or path is missing or the
+// value is not a string or number
+func (s CorrelationSource) extract(attributes map[string]string, data []byte) (string, bool) {
+	if s.Attribute != "" {
+		value, ok := attributes[s.Attribute]
+		return value, ok && value != ""
+	}
+	if len(s.Path) == 0 {
+		return "", false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return "", false
+	}
+	for _, step := range s.Path {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]any)
+			if !ok {
+				return "", false
+			}
+			value, ok = object[step]
+			if !ok {
+				return "", false
+			}
+		case int:
+			array, ok := value.([]any)
+			if !ok || step >= len(array) {
+				return "", false
+			}
+			value = array[step]
+		}
+	}
+	switch value := value.(type) {
+	case string:
+		return value, value != ""
+	case json.Number:
+		return value.String(), true
+	}
+	return "", false
+}
+
+// setCorrelationID adds the correlation ID header to the request, falling back to the message ID when the
+// source yields no ID unless --correlation-id-fallback is none
+func (c *consumer) setCorrelationID(msg *pubsub.Message, payload *PubSubMessage) {
+	id, ok := c.cfg.CorrelationIDSource.extract(msg.Attributes, msg.Data)
+	if !ok {
+		if c.cfg.CorrelationIDFallback == "none" {
+			return
+		}
+		id = msg.ID
+	}
+	if payload.headers == nil {
+		payload.headers = make(map[string]string, 1)
+	}
+	payload.headers[c.cfg.CorrelationIDHeader] = id
+}
@@ -0,0 +1,73 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/pubsub"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+)
+
+// credentialsEnv is the environment variable naming a credentials file, the second source of the fallback chain
+const credentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+
+// credentialScopes are requested for every credential source
+var credentialScopes = []string{pubsub.ScopePubSub, pubsub.ScopeCloudPlatform}
+
+// findCredentials tries, in order, the --credentials-file service account key, the file named by
+// GOOGLE_APPLICATION_CREDENTIALS and Application Default Credentials, logging the source that was used. The
+// same binary can then run with a key file locally, an environment variable in CI and workload identity on GKE.
+func findCredentials(ctx context.Context, cfg *Config) (option.ClientOption, error) {
+	var errs []error
+
+	if cfg.CredentialsFile != "" {
+		creds, err := credentialsFromFile(ctx, cfg.CredentialsFile, google.ServiceAccount)
+		if err == nil {
+			cfg.log().Printf("Using credentials from key file %s", cfg.CredentialsFile)
+			return option.WithCredentials(creds), nil
+		}
+		cfg.log().Printf("Warning: credentials key file %s is unusable, trying the next source: %v", cfg.CredentialsFile, err)
+		errs = append(errs, fmt.Errorf("key file: %w", err))
+	}
+
+	if path := os.Getenv(credentialsEnv); path != "" {
+		creds, err := credentialsFromFile(ctx, path, "")
+		if err == nil {
+			cfg.log().Printf("Using credentials from %s file %s", credentialsEnv, path)
+			return option.WithCredentials(creds), nil
+		}
+		cfg.log().Printf("Warning: %s file %s is unusable, trying the next source: %v", credentialsEnv, path, err)
+		errs = append(errs, fmt.Errorf("%s: %w", credentialsEnv, err))
+	}
+
+	creds, err := google.FindDefaultCredentials(ctx, credentialScopes...)
+	if err == nil {
+		cfg.log().Println("Using Application Default Credentials")
+		return option.WithCredentials(creds), nil
+	}
+	errs = append(errs, fmt.Errorf("application default credentials: %w", err))
+	return nil, fmt.Errorf("%w: %w", ErrNoCredentials, errors.Join(errs...))
+}
+
+// credentialsFromFile loads a credentials JSON file, requiring it to be of credType when set and otherwise
+// accepting the type the file declares
+func credentialsFromFile(ctx context.Context, path string, credType google.CredentialsType) (*google.Credentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if credType == "" {
+		var file struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		credType = google.CredentialsType(file.Type)
+	}
+	return google.CredentialsFromJSONWithType(ctx, data, credType, credentialScopes...)
+}
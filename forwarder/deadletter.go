@@ -0,0 +1,82 @@
+package forwarder
+
+import (
+	"context"
+	"log"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// deadLetterer republishes messages that can never be delivered to a dead-letter topic
+type deadLetterer struct {
+	topic *pubsub.Topic
+	// subscription labels the metrics of the messages it handles
+	subscription string
+	logger       *log.Logger
+}
+
+// newDeadLetterer returns a dead-letterer for the configured topic, or nil when no topic is configured
+func newDeadLetterer(client *pubsub.Client, cfg *Config) *deadLetterer {
+	if cfg.DeadLetterTopic == "" {
+		return nil
+	}
+	topic := client.Topic(cfg.DeadLetterTopic)
+	// Ordered messages keep their ordering key, which the client only publishes with ordering enabled
+	topic.EnableMessageOrdering = true
+	return &deadLetterer{topic: topic, subscription: cfg.Subscription, logger: cfg.log()}
+}
+
+// handle publishes the message to the dead-letter topic and Acks it once published, returning whether it was
+// Acked. The message is Nacked if publishing fails or no dead-letter topic is configured so it is never lost.
+func (d *deadLetterer) handle(ctx context.Context, msg *pubsub.Message, reason string) bool {
+	if d.topic == nil {
+		d.logger.Printf("No dead-letter topic configured for message ID %s (%s), Nacking", msg.ID, reason)
+		msg.Nack()
+		return false
+	}
+
+	attributes := make(map[string]string, len(msg.Attributes)+1)
+	for key, value := range msg.Attributes {
+		attributes[key] = value
+	}
+	attributes["deadLetterReason"] = reason
+
+	result := d.topic.Publish(ctx, &pubsub.Message{
+		Data:        msg.Data,
+		Attributes:  attributes,
+		OrderingKey: msg.OrderingKey,
+	})
+	if _, err := result.Get(ctx); err != nil {
+		d.logger.Printf("Error dead-lettering message ID %s: %v", msg.ID, err)
+		// A failed ordered publish pauses its key until resumed, which would fail every later message with it
+		if msg.OrderingKey != "" {
+			d.topic.ResumePublish(msg.OrderingKey)
+		}
+		msg.Nack()
+		return false
+	}
+
+	d.logger.Printf("Dead-lettered message ID %s to %s: %s", msg.ID, d.topic.ID(), reason)
+	msg.Ack()
+	return true
+}
+
+// handleOrDrop dead-letters a message that fails on every redelivery like handle, but Acks and drops it when
+// there is no dead-letter topic, since Nacking it would redeliver it forever. A message with a delivery attempt
+// comes from a subscription with a server-side dead-letter policy, which dead-letters it after Nacks instead.
+func (d *deadLetterer) handleOrDrop(ctx context.Context, msg *pubsub.Message, reason, kind string) bool {
+	if d.topic == nil && msg.DeliveryAttempt == nil {
+		undeliverableDropped.WithLabelValues(d.subscription, kind).Inc()
+		d.logger.Printf("Dropping message ID %s (%s), no dead-letter topic configured", msg.ID, reason)
+		msg.Ack()
+		return true
+	}
+	return d.handle(ctx, msg, reason)
+}
+
+// Stop flushes pending publishes to the dead-letter topic
+func (d *deadLetterer) Stop() {
+	if d != nil {
+		d.topic.Stop()
+	}
+}
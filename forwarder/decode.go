@@ -0,0 +1,179 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// registryFetchTimeout bounds each request to the schema registry
+const registryFetchTimeout = 10 * time.Second
+
+// errSchemaUnavailable marks a decode failure caused by the schema registry rather than the message, so the
+// message is Nacked and retried instead of dead-lettered
+var errSchemaUnavailable = errors.New("schema unavailable")
+
+// decoder converts Avro or Protobuf message data to JSON before it is forwarded
+type decoder struct {
+	format string
+	// avro is the Avro schema from --decode-schema, nil when schemas come from the registry
+	avro *goavro.Codec
+	// message is the Protobuf message type from --decode-schema and --decode-message-type
+	message protoreflect.MessageType
+
+	registry    string
+	idAttribute string
+	client      *http.Client
+	mu          sync.Mutex
+	cache       map[string]*goavro.Codec
+}
+
+// newDecoder parses the schema, or returns nil when no decode format is configured. Schemas fetched from the
+// registry are parsed on first use and cached by ID.
+func newDecoder(cfg *Config) (*decoder, error) {
+	if cfg.DecodeFormat == "" {
+		return nil, nil
+	}
+	d := &decoder{
+		format:      cfg.DecodeFormat,
+		registry:    cfg.DecodeSchemaRegistryURL,
+		idAttribute: cfg.DecodeSchemaIDAttribute,
+		client:      &http.Client{Timeout: registryFetchTimeout},
+		cache:       make(map[string]*goavro.Codec),
+	}
+	if d.registry != "" {
+		return d, nil
+	}
+
+	schema, err := os.ReadFile(cfg.DecodeSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decode schema: %w", err)
+	}
+	switch cfg.DecodeFormat {
+	case "avro":
+		if d.avro, err = goavro.NewCodec(string(schema)); err != nil {
+			return nil, fmt.Errorf("failed to parse Avro schema %s: %w", cfg.DecodeSchema, err)
+		}
+	case "protobuf":
+		if d.message, err = loadMessageType(schema, cfg.DecodeMessageType); err != nil {
+			return nil, fmt.Errorf("failed to load Protobuf descriptor set %s: %w", cfg.DecodeSchema, err)
+		}
+	}
+	return d, nil
+}
+
+// loadMessageType finds the named message in a serialized FileDescriptorSet, as written by protoc
+// --descriptor_set_out --include_imports
+func loadMessageType(descriptorSet []byte, name string) (protoreflect.MessageType, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(descriptorSet, &set); err != nil {
+		return nil, err
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, err
+	}
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("message type %s: %w", name, err)
+	}
+	message, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message type", name)
+	}
+	return dynamicpb.NewMessageType(message), nil
+}
+
+// decode returns the message data converted to JSON
+func (d *decoder) decode(ctx context.Context, msg *pubsub.Message) ([]byte, error) {
+	if d.format == "protobuf" {
+		message := d.message.New().Interface()
+		if err := proto.Unmarshal(msg.Data, message); err != nil {
+			return nil, fmt.Errorf("failed to decode Protobuf data: %w", err)
+		}
+		return protojson.Marshal(message)
+	}
+
+	codec := d.avro
+	if codec == nil {
+		var err error
+		if codec, err = d.registryCodec(ctx, msg.Attributes[d.idAttribute]); err != nil {
+			return nil, err
+		}
+	}
+	native, _, err := codec.NativeFromBinary(msg.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Avro data: %w", err)
+	}
+	return codec.TextualFromNative(nil, native)
+}
+
+// registryCodec returns the Avro codec for the schema ID, fetching it from the registry the first time
+func (d *decoder) registryCodec(ctx context.Context, id string) (*goavro.Codec, error) {
+	if id == "" {
+		return nil, fmt.Errorf("missing schema ID attribute %s", d.idAttribute)
+	}
+	d.mu.Lock()
+	codec, ok := d.cache[id]
+	d.mu.Unlock()
+	if ok {
+		return codec, nil
+	}
+
+	// Fetch with a Confluent compatible registry's GET /schemas/ids/{id}
+	req, err := http.NewRequestWithContext(ctx, "GET", d.registry+"/schemas/ids/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema registry request: %w", err)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch schema %s: %w", errSchemaUnavailable, id, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read schema %s: %w", errSchemaUnavailable, id, err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("schema %s not found in the registry", id)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: schema registry returned status %d for schema %s", errSchemaUnavailable, resp.StatusCode, id)
+	}
+
+	var schema struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+	if err := json.Unmarshal(body, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema registry response for schema %s: %w", id, err)
+	}
+	if schema.SchemaType != "" && schema.SchemaType != "AVRO" {
+		return nil, fmt.Errorf("schema %s is %s, not AVRO", id, schema.SchemaType)
+	}
+	codec, err = goavro.NewCodec(schema.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Avro schema %s: %w", id, err)
+	}
+
+	d.mu.Lock()
+	d.cache[id] = codec
+	d.mu.Unlock()
+	return codec, nil
+}
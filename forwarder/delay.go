@@ -0,0 +1,76 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// parseAttributeTime parses a time attribute given as an RFC 3339 timestamp or Unix seconds
+func parseAttributeTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	due, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be an RFC 3339 timestamp or Unix seconds: %q", value)
+	}
+	return due, nil
+}
+
+// waitUntilDue holds a message carrying a future delivery time in --deliver-after-attribute until that time,
+// while the client library keeps extending its lease. A message due further away than --max-delay is Nacked
+// after --nack-delay so it is redelivered closer to its time rather than holding a lease indefinitely. It
+// returns false when the message was Nacked.
+func (c *consumer) waitUntilDue(ctx context.Context, msg *pubsub.Message) bool {
+	value, ok := msg.Attributes[c.cfg.DeliverAfterAttribute]
+	if c.cfg.DeliverAfterAttribute == "" || !ok {
+		return true
+	}
+	due, err := parseAttributeTime(value)
+	if err != nil {
+		c.cfg.log().Printf("Ignoring invalid %s attribute of message ID %s: %v", c.cfg.DeliverAfterAttribute, msg.ID, err)
+		return true
+	}
+	wait := time.Until(due)
+	if wait <= 0 {
+		return true
+	}
+	if wait > c.cfg.MaxDelay {
+		c.cfg.log().Printf("Nacking message ID %s: due in %s, beyond --max-delay %s", msg.ID, wait.Round(time.Second), c.cfg.MaxDelay)
+		c.nack(ctx, msg)
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-waitDone(ctx):
+		msg.Nack()
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// expired reports whether the message carries a time in --expiry-attribute that has passed. A malformed time
+// counts as expired with --expiry-malformed=drop and is otherwise forwarded with a warning.
+func (c *consumer) expired(msg *pubsub.Message) bool {
+	value, ok := msg.Attributes[c.cfg.ExpiryAttribute]
+	if c.cfg.ExpiryAttribute == "" || !ok {
+		return false
+	}
+	expiresAt, err := parseAttributeTime(value)
+	if err != nil {
+		if c.cfg.ExpiryMalformed == "drop" {
+			c.cfg.log().Printf("Dropping message ID %s with invalid %s attribute: %v", msg.ID, c.cfg.ExpiryAttribute, err)
+			return true
+		}
+		c.cfg.log().Printf("Warning: forwarding message ID %s with invalid %s attribute: %v", msg.ID, c.cfg.ExpiryAttribute, err)
+		return false
+	}
+	return time.Now().After(expiresAt)
+}
@@ -0,0 +1,218 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskBuffer stores messages that failed delivery in a bounded on-disk queue so they can be Acked, and
+// redelivers them once the downstream recovers. Each message is one JSON file named so that lexical order is
+// the order it was buffered in.
+type diskBuffer struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	size     int64
+	deliver  Deliverer
+	// subscription labels the metrics of the buffered messages
+	subscription string
+	logger       *log.Logger
+}
+
+// bufferedMessage is a buffered message as written to disk, keeping the per-message state set while it was
+// prepared that the payload's JSON form leaves out
+type bufferedMessage struct {
+	Payload *PubSubMessage    `json:"payload"`
+	Body    []byte            `json:"body,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Key     string            `json:"key,omitempty"`
+}
+
+// newBufferedMessage captures the payload along with its rewritten body, headers, URL, method and key
+func newBufferedMessage(payload *PubSubMessage) bufferedMessage {
+	return bufferedMessage{
+		Payload: payload,
+		Body:    payload.body,
+		Headers: payload.headers,
+		URL:     payload.url,
+		Method:  payload.method,
+		Key:     payload.key,
+	}
+}
+
+// parseBufferedMessage restores a buffered message, accepting the bare payload written by earlier versions
+func parseBufferedMessage(data []byte) (*PubSubMessage, error) {
+	var buffered bufferedMessage
+	if err := json.Unmarshal(data, &buffered); err != nil {
+		return nil, err
+	}
+	if buffered.Payload == nil {
+		var payload PubSubMessage
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return nil, err
+		}
+		return &payload, nil
+	}
+	payload := buffered.Payload
+	payload.body = buffered.Body
+	payload.headers = buffered.Headers
+	payload.url = buffered.URL
+	payload.method = buffered.Method
+	payload.key = buffered.Key
+	return payload, nil
+}
+
+// newDiskBuffer opens the buffer directory, counting messages left from a previous run against the limit,
+// or returns nil when no directory is configured
+func newDiskBuffer(cfg *Config, deliver Deliverer) (*diskBuffer, error) {
+	if cfg.DiskBufferDir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.DiskBufferDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create disk buffer directory %s: %w", cfg.DiskBufferDir, err)
+	}
+
+	b := &diskBuffer{dir: cfg.DiskBufferDir, maxBytes: cfg.DiskBufferMaxBytes, deliver: deliver, subscription: cfg.Subscription, logger: cfg.log()}
+	files, err := b.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range files {
+		if info, err := os.Stat(filepath.Join(b.dir, name)); err == nil {
+			b.size += info.Size()
+		}
+	}
+	if len(files) > 0 {
+		cfg.log().Printf("Disk buffer %s holds %d messages from a previous run", b.dir, len(files))
+	}
+	return b, nil
+}
+
+// files returns the buffered message files, oldest first
+func (b *diskBuffer) files() ([]string, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read disk buffer directory %s: %w", b.dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// store durably writes the message to the buffer, failing when the buffer is full
+func (b *diskBuffer) store(payload *PubSubMessage) error {
+	data, err := json.Marshal(newBufferedMessage(payload))
+	if err != nil {
+		return fmt.Errorf("failed to marshal buffered message: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.size+int64(len(data)) > b.maxBytes {
+		return fmt.Errorf("disk buffer full at %d bytes", b.size)
+	}
+
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), filepath.Base(payload.Message.MessageID))
+	tmp := filepath.Join(b.dir, name+".tmp")
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create buffered message: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(b.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write buffered message: %w", err)
+	}
+	b.size += int64(len(data))
+	return nil
+}
+
+// run redelivers buffered messages oldest first every interval until the context is cancelled, stopping each
+// pass at the first failure since the downstream is most likely still unavailable
+func (b *diskBuffer) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		b.drain(ctx)
+	}
+}
+
+// drain redelivers buffered messages until one fails or the buffer is empty. A message that fails in a way
+// retrying cannot fix, such as a 4xx response, is dropped so it does not hold up the messages behind it.
+func (b *diskBuffer) drain(ctx context.Context) {
+	files, err := b.files()
+	if err != nil {
+		b.logger.Printf("Error listing disk buffer: %v", err)
+		return
+	}
+	for _, name := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		path := filepath.Join(b.dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			b.logger.Printf("Error reading buffered message %s: %v", name, err)
+			continue
+		}
+		payload, err := parseBufferedMessage(data)
+		if err != nil {
+			b.logger.Printf("Discarding unreadable buffered message %s: %v", name, err)
+			b.remove(path, int64(len(data)))
+			continue
+		}
+
+		if err := b.deliver(ctx, payload); err != nil {
+			if isPermanent(err) || isClientError(err) {
+				b.logger.Printf("Dropping buffered message ID %s, its redelivery cannot succeed: %v", payload.Message.MessageID, err)
+				diskBufferDropped.WithLabelValues(b.subscription).Inc()
+				b.remove(path, int64(len(data)))
+				continue
+			}
+			b.logger.Printf("Disk buffer redelivery of message ID %s failed, retrying later: %v", payload.Message.MessageID, err)
+			return
+		}
+		b.logger.Printf("Redelivered buffered message ID %s", payload.Message.MessageID)
+		b.remove(path, int64(len(data)))
+	}
+}
+
+// remove deletes a buffered message and releases its space
+func (b *diskBuffer) remove(path string, size int64) {
+	if err := os.Remove(path); err != nil {
+		b.logger.Printf("Error removing buffered message %s: %v", path, err)
+		return
+	}
+	b.mu.Lock()
+	b.size -= size
+	b.mu.Unlock()
+}
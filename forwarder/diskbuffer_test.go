@@ -0,0 +1,77 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestDiskBufferKeepsPreparedState(t *testing.T) {
+	var got *PubSubMessage
+	b, err := newDiskBuffer(&Config{DiskBufferDir: t.TempDir(), DiskBufferMaxBytes: 1 << 20}, func(ctx context.Context, payload *PubSubMessage) error {
+		got = payload
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := &PubSubMessage{
+		body:    []byte(`{"rewritten":true}`),
+		headers: map[string]string{"X-Correlation-ID": "abc"},
+		url:     "http://example.com/orders",
+		method:  http.MethodPut,
+		key:     "customer-1",
+	}
+	payload.Message.MessageID = "1"
+	if err := b.store(payload); err != nil {
+		t.Fatal(err)
+	}
+	b.drain(context.Background())
+
+	if got == nil {
+		t.Fatal("buffered message was not redelivered")
+	}
+	if string(got.body) != `{"rewritten":true}` || got.headers["X-Correlation-ID"] != "abc" ||
+		got.url != payload.url || got.method != payload.method || got.key != payload.key {
+		t.Errorf("redelivered message lost its prepared state: %+v", got)
+	}
+	if files, _ := b.files(); len(files) != 0 {
+		t.Errorf("delivered message left in the buffer: %v", files)
+	}
+}
+
+func TestDiskBufferDrainDropsPermanentFailures(t *testing.T) {
+	var delivered []string
+	b, err := newDiskBuffer(&Config{DiskBufferDir: t.TempDir(), DiskBufferMaxBytes: 1 << 20}, func(ctx context.Context, payload *PubSubMessage) error {
+		switch payload.Message.MessageID {
+		case "1-rejected":
+			return &PostError{StatusCode: http.StatusBadRequest, Err: errors.New("bad request")}
+		case "3-unavailable":
+			return &PostError{StatusCode: http.StatusServiceUnavailable, Err: errors.New("unavailable")}
+		}
+		delivered = append(delivered, payload.Message.MessageID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1-rejected", "2-ok", "3-unavailable", "4-later"} {
+		payload := &PubSubMessage{}
+		payload.Message.MessageID = id
+		if err := b.store(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.drain(context.Background())
+
+	// The 400 is dropped, the 503 stops the pass and keeps itself and the message behind it
+	if len(delivered) != 1 || delivered[0] != "2-ok" {
+		t.Errorf("delivered %v, want [2-ok]", delivered)
+	}
+	if files, _ := b.files(); len(files) != 2 {
+		t.Errorf("buffer holds %d messages, want 2", len(files))
+	}
+}
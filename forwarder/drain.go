@@ -0,0 +1,102 @@
+package forwarder
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// drainer lets in-flight messages finish after shutdown begins, Nacking whatever is still unfinished when the
+// drain timeout expires so it is redelivered promptly to another instance instead of waiting out its lease
+type drainer struct {
+	mu       sync.Mutex
+	inflight map[*pubsub.Message]struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	logger   *log.Logger
+}
+
+// shutdownKey is the context key under which the drainer stores the shutdown signal for waiting messages
+type shutdownKey struct{}
+
+// waitDone returns the channel that ends a wait that is not a delivery, such as a Nack delay or a hold. While
+// draining with --drain-nack-waiting that is shutdown itself, so waiting messages are handed off promptly
+// instead of holding the drain until it times out.
+func waitDone(ctx context.Context) <-chan struct{} {
+	if shutdown, ok := ctx.Value(shutdownKey{}).(<-chan struct{}); ok {
+		return shutdown
+	}
+	return ctx.Done()
+}
+
+// newDrainer returns a drainer whose delivery context outlives ctx by up to timeout. With nackWaiting set,
+// messages waiting rather than being delivered stop waiting as soon as shutdown begins.
+func newDrainer(ctx context.Context, timeout time.Duration, nackWaiting bool, logger *log.Logger) *drainer {
+	deliverCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	if nackWaiting {
+		deliverCtx = context.WithValue(deliverCtx, shutdownKey{}, ctx.Done())
+	}
+	d := &drainer{
+		inflight: make(map[*pubsub.Message]struct{}),
+		ctx:      deliverCtx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		logger:   logger,
+	}
+
+	go func() {
+		select {
+		case <-d.done:
+			return
+		case <-ctx.Done():
+		}
+		d.logger.Printf("Draining in-flight messages for up to %s", timeout)
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-d.done:
+		case <-timer.C:
+			d.nackUnfinished()
+		}
+	}()
+	return d
+}
+
+// wrap returns a Receive handler that tracks each message while handle runs with the delivery context
+func (d *drainer) wrap(handle func(context.Context, *pubsub.Message)) func(context.Context, *pubsub.Message) {
+	return func(_ context.Context, msg *pubsub.Message) {
+		d.mu.Lock()
+		d.inflight[msg] = struct{}{}
+		d.mu.Unlock()
+
+		handle(d.ctx, msg)
+
+		d.mu.Lock()
+		delete(d.inflight, msg)
+		d.mu.Unlock()
+	}
+}
+
+// nackUnfinished Nacks every message still being handled and cancels their deliveries. A message already
+// settled by its handler ignores the Nack, and the handler's later Ack or Nack is ignored in turn.
+func (d *drainer) nackUnfinished() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.inflight) > 0 {
+		d.logger.Printf("Drain timeout expired, Nacking %d unfinished messages", len(d.inflight))
+	}
+	for msg := range d.inflight {
+		msg.Nack()
+	}
+	d.cancel()
+}
+
+// stop ends the drain once Receive has returned
+func (d *drainer) stop() {
+	close(d.done)
+	d.cancel()
+}
@@ -0,0 +1,112 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// enricher adds static reference data from --enrichment-file to messages, looked up by an attribute value
+type enricher struct {
+	path   string
+	values atomic.Pointer[map[string]map[string]string]
+	logger *log.Logger
+}
+
+// newEnricher loads the enrichment file, or returns nil when none is configured
+func newEnricher(cfg *Config) (*enricher, error) {
+	if cfg.EnrichmentFile == "" {
+		return nil, nil
+	}
+	e := &enricher{path: cfg.EnrichmentFile, logger: cfg.log()}
+	if err := e.load(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// load reads the enrichment file and replaces the lookup table. A JSON file is an object mapping each lookup
+// value to an object of fields, a CSV file has a header row naming the fields after the lookup value column.
+func (e *enricher) load() error {
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		return fmt.Errorf("failed to read enrichment file: %w", err)
+	}
+
+	values := make(map[string]map[string]string)
+	if strings.EqualFold(filepath.Ext(e.path), ".csv") {
+		records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+		if err != nil {
+			return fmt.Errorf("failed to parse enrichment file %s: %w", e.path, err)
+		}
+		if len(records) == 0 {
+			return fmt.Errorf("enrichment file %s has no header row", e.path)
+		}
+		header := records[0]
+		for _, record := range records[1:] {
+			fields := make(map[string]string, len(header)-1)
+			for i := 1; i < len(header); i++ {
+				fields[header[i]] = record[i]
+			}
+			values[record[0]] = fields
+		}
+	} else if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to parse enrichment file %s: %w", e.path, err)
+	}
+
+	e.values.Store(&values)
+	e.logger.Printf("Loaded %d enrichment entries from %s", len(values), e.path)
+	return nil
+}
+
+// lookup returns the fields for the value of the lookup attribute, and whether an entry was found
+func (e *enricher) lookup(value string) (map[string]string, bool) {
+	fields, ok := (*e.values.Load())[value]
+	return fields, ok
+}
+
+// reloadOnHangup reloads the enrichment file on every SIGHUP until the context is cancelled, keeping the
+// previous table when the new file is invalid
+func (e *enricher) reloadOnHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			if err := e.load(); err != nil {
+				e.logger.Printf("Error reloading enrichment file, keeping the previous entries: %v", err)
+			}
+		}
+	}
+}
+
+// enrich adds the enrichment fields for the message to the payload or as HTTP headers. It returns false when
+// the message has no entry and --enrichment-missing is drop.
+func (c *consumer) enrich(payload *PubSubMessage) bool {
+	fields, ok := c.enricher.lookup(payload.Message.Attributes[c.cfg.EnrichmentAttribute])
+	if !ok {
+		return c.cfg.EnrichmentMissing != "drop"
+	}
+	if c.cfg.EnrichmentTarget == "headers" {
+		if payload.headers == nil {
+			payload.headers = make(map[string]string, len(fields))
+		}
+		for name, value := range fields {
+			payload.headers[name] = value
+		}
+		return true
+	}
+	payload.Enrichment = fields
+	return true
+}
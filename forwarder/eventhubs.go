@@ -0,0 +1,128 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// eventHubsTokenLifetime is how long each shared access signature is valid
+const eventHubsTokenLifetime = time.Hour
+
+// eventHubsReservedHeaders are set by the sink itself, so attributes of the same name cannot replace them
+var eventHubsReservedHeaders = map[string]bool{"Authorization": true, "Content-Type": true, "Brokerproperties": true}
+
+// eventHubsSink sends each transformed message as an event to an Azure Event Hub through its REST API
+type eventHubsSink struct {
+	endpoint string
+	keyName  string
+	key      string
+	client   *http.Client
+	cfg      *Config
+}
+
+// newEventHubsSink parses the namespace connection string and targets the hub, which defaults to the
+// connection string's EntityPath
+func newEventHubsSink(cfg *Config, connectionString, hub string) (*eventHubsSink, error) {
+	var host, keyName, key, entityPath string
+	for _, part := range strings.Split(connectionString, ";") {
+		name, value, _ := strings.Cut(part, "=")
+		switch name {
+		case "Endpoint":
+			parsed, err := url.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Event Hubs connection string endpoint: %w", err)
+			}
+			host = parsed.Host
+		case "SharedAccessKeyName":
+			keyName = value
+		case "SharedAccessKey":
+			key = value
+		case "EntityPath":
+			entityPath = value
+		}
+	}
+	if hub == "" {
+		hub = entityPath
+	}
+	if host == "" || keyName == "" || key == "" || hub == "" {
+		return nil, fmt.Errorf("invalid Event Hubs connection string: Endpoint, SharedAccessKeyName, SharedAccessKey and a hub name are required")
+	}
+
+	cfg.log().Printf("Sending to Event Hub: %s/%s", host, hub)
+	return &eventHubsSink{
+		endpoint: "https://" + host + "/" + hub + "/messages",
+		keyName:  keyName,
+		key:      key,
+		client:   &http.Client{Timeout: defaultHTTPTimeout, Transport: transportFor(cfg)},
+		cfg:      cfg,
+	}, nil
+}
+
+// token returns a shared access signature authorizing sends to the hub
+func (e *eventHubsSink) token() string {
+	resource := url.QueryEscape(strings.TrimSuffix(e.endpoint, "/messages"))
+	expiry := strconv.FormatInt(time.Now().Add(eventHubsTokenLifetime).Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(e.key))
+	mac.Write([]byte(resource + "\n" + expiry))
+	signature := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s&skn=%s", resource, signature, expiry, e.keyName)
+}
+
+// Send sends the JSON payload as an event, mapping attributes to event properties and the routing key to
+// the partition key
+func (e *eventHubsSink) Send(ctx context.Context, payload *PubSubMessage) error {
+	data, err := marshalPayload(payload, e.cfg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create Event Hubs request: %w", err)
+	}
+	for key, value := range payload.Message.Attributes {
+		// An attribute that cannot be a header would fail every retry of the request the same way
+		if eventHubsReservedHeaders[http.CanonicalHeaderKey(key)] || !httpguts.ValidHeaderFieldName(key) || !httpguts.ValidHeaderFieldValue(value) {
+			e.cfg.log().Printf("Skipping attribute %q of message ID %s, which cannot be sent as an Event Hubs property", key, payload.Message.MessageID)
+			continue
+		}
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Authorization", e.token())
+	req.Header.Set("Content-Type", "application/atom+xml;type=entry;charset=utf-8")
+	if key := payload.routingKey(); key != "" {
+		properties, err := json.Marshal(map[string]string{"PartitionKey": key})
+		if err != nil {
+			return fmt.Errorf("failed to marshal Event Hubs broker properties: %w", err)
+		}
+		req.Header.Set("BrokerProperties", string(properties))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send event: %w", err)
+	}
+	defer drainAndClose(resp.Body, e.cfg.MaxResponseBytes)
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to send event. HTTP Status: %s", resp.Status)
+	}
+	return nil
+}
+
+// Close closes the idle connections to the Event Hubs namespace
+func (e *eventHubsSink) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
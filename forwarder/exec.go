@@ -0,0 +1,67 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// execSink pipes each transformed message to a new process running a shell command
+type execSink struct {
+	command string
+	slots   *semaphore.Weighted
+	cfg     *Config
+}
+
+// newExecSink creates a sink that runs command once per message, with at most concurrency processes at a time
+func newExecSink(cfg *Config, command string) *execSink {
+	return &execSink{command: command, slots: semaphore.NewWeighted(int64(cfg.ExecConcurrency)), cfg: cfg}
+}
+
+// Send runs the command with the JSON payload on stdin and the attributes as PUBSUB_ATTR_ environment variables,
+// succeeding when the command exits with status 0
+func (e *execSink) Send(ctx context.Context, payload *PubSubMessage) error {
+	data, err := marshalPayload(payload, e.cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := e.slots.Acquire(ctx, 1); err != nil {
+		return err
+	}
+	defer e.slots.Release(1)
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", e.command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "PUBSUB_MESSAGE_ID="+payload.Message.MessageID)
+	for key, value := range payload.Message.Attributes {
+		cmd.Env = append(cmd.Env, "PUBSUB_ATTR_"+envName(key)+"="+value)
+	}
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("exec command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// envName converts an attribute name to an environment variable name, upper cased with any other character
+// than a letter, digit or underscore replaced by an underscore
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
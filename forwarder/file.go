@@ -0,0 +1,114 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileSink appends each transformed message as a JSON line to a local file, rotating to a new file once the
+// size or interval limit is reached
+type fileSink struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	interval time.Duration
+	cfg      *Config
+
+	file    *os.File
+	size    int64
+	opened  time.Time
+	rotates bool
+}
+
+// newFileSink opens the output file, with rotation enabled each file is named after the time it was opened
+func newFileSink(cfg *Config, path string) (*fileSink, error) {
+	// Each line must be a single JSON document, so pretty printing is disabled for this sink
+	lineCfg := *cfg
+	lineCfg.Pretty = false
+	f := &fileSink{
+		path:     path,
+		maxSize:  cfg.RotateSize,
+		interval: cfg.RotateInterval,
+		cfg:      &lineCfg,
+		rotates:  cfg.RotateSize > 0 || cfg.RotateInterval > 0,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the next output file for appending
+func (f *fileSink) open() error {
+	name := f.path
+	if f.rotates {
+		ext := filepath.Ext(f.path)
+		name = strings.TrimSuffix(f.path, ext) + "-" + time.Now().UTC().Format("20060102T150405.000Z") + ext
+	}
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file %s: %w", name, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat output file %s: %w", name, err)
+	}
+
+	f.cfg.log().Printf("Writing messages to file: %s", name)
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+// rotate closes the current file and opens a new one when the size or interval limit has been reached
+func (f *fileSink) rotate() error {
+	if !f.rotates || f.size == 0 {
+		return nil
+	}
+	if (f.maxSize <= 0 || f.size < f.maxSize) && (f.interval <= 0 || time.Since(f.opened) < f.interval) {
+		return nil
+	}
+	if err := f.file.Close(); err != nil {
+		f.cfg.log().Printf("Error closing output file %s: %v", f.file.Name(), err)
+	}
+	return f.open()
+}
+
+// Send writes the message as one JSON line and syncs it to disk so it is durable before the message is Acked
+func (f *fileSink) Send(ctx context.Context, payload *PubSubMessage) error {
+	data, err := marshalPayload(payload, f.cfg)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.rotate(); err != nil {
+		return err
+	}
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write to output file %s: %w", f.file.Name(), err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync output file %s: %w", f.file.Name(), err)
+	}
+	return nil
+}
+
+// Close closes the current output file
+func (f *fileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
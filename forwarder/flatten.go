@@ -0,0 +1,66 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// payloadFields returns the serialized payload as a map of its fields for rewriting before it is marshaled
+func payloadFields(value any) (map[string]any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["message"].(map[string]any); !ok {
+		return nil, fmt.Errorf("payload has no message object")
+	}
+	return fields, nil
+}
+
+// parseJSONAttributes replaces the string value of each named attribute with the JSON value it encodes, so it is
+// embedded as a nested object instead of double encoded. A value that is not valid JSON is kept as the string.
+func parseJSONAttributes(fields map[string]any, names []string, messageID string, logger *log.Logger) {
+	attributes, _ := fields["message"].(map[string]any)["attributes"].(map[string]any)
+	for _, name := range names {
+		attribute, ok := attributes[name].(string)
+		if !ok {
+			continue
+		}
+		if !json.Valid([]byte(attribute)) {
+			logger.Printf("Warning: attribute %q of message ID %s is not valid JSON, keeping it as a string", name, messageID)
+			continue
+		}
+		// Embedding the raw JSON keeps numbers exactly as published
+		attributes[name] = json.RawMessage(attribute)
+	}
+}
+
+// flattenAttributes promotes each attribute to a top-level field named with the configured prefix. An attribute
+// whose field name collides with a payload field, such as message or subscription, stays nested under
+// message.attributes so no value is lost.
+func flattenAttributes(fields map[string]any, prefix string, messageID string, logger *log.Logger) {
+	message := fields["message"].(map[string]any)
+	attributes, _ := message["attributes"].(map[string]any)
+
+	nested := make(map[string]any)
+	for key, attribute := range attributes {
+		name := prefix + key
+		if _, exists := fields[name]; exists {
+			logger.Printf("Attribute %q of message ID %s collides with payload field %q, keeping it nested",
+				key, messageID, name)
+			nested[key] = attribute
+			continue
+		}
+		fields[name] = attribute
+	}
+	if len(nested) > 0 {
+		message["attributes"] = nested
+	} else {
+		delete(message, "attributes")
+	}
+}
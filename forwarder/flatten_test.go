@@ -0,0 +1,32 @@
+package forwarder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseJSONAttributes(t *testing.T) {
+	payload := &PubSubMessage{}
+	payload.Message.Attributes = map[string]string{
+		"metadata": `{"source":"billing","id":9007199254740993}`,
+		"tags":     `["a","b"]`,
+		"broken":   `{"source":`,
+		"plain":    `{"left":"as is"}`,
+	}
+	data, err := marshalPayload(payload, &Config{ParseJSONAttributes: []string{"metadata", "tags", "broken", "missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		// Valid JSON is embedded as a nested value, keeping numbers exactly
+		`"metadata":{"source":"billing","id":9007199254740993}`,
+		`"tags":["a","b"]`,
+		// Invalid JSON and attributes that are not named stay strings
+		`"broken":"{\"source\":"`,
+		`"plain":"{\"left\":\"as is\"}"`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("payload %s does not contain %s", data, want)
+		}
+	}
+}
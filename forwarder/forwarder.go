@@ -0,0 +1,1301 @@
+// Package forwarder consumes Pub/Sub messages, transforms them into the structure used by Cloud Run push
+// subscriptions, and delivers them to a pluggable destination.
+package forwarder
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Errors returned by Run for each class of failure so callers can decide whether to restart or alert
+var (
+	// ErrInvalidConfig is returned when the configuration cannot be used, such as an unloadable schema
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrPubSubSetup is returned when the Pub/Sub client or subscription cannot be set up, such as a failed seek
+	// or subscription creation. It is an authentication failure only when IsAuthError also reports one.
+	ErrPubSubSetup = errors.New("Pub/Sub setup failed")
+	// ErrNoCredentials is returned when none of the Pub/Sub credential sources is usable
+	ErrNoCredentials = errors.New("no usable credentials found")
+	// ErrSubscriptionNotFound is returned when the configured subscription does not exist
+	ErrSubscriptionNotFound = errors.New("subscription does not exist")
+	// ErrDownstreamUnreachable is returned when the startup probe never reaches the URL
+	ErrDownstreamUnreachable = errors.New("downstream unreachable")
+	// ErrReceive is returned when receiving messages from the subscription fails
+	ErrReceive = errors.New("error receiving messages")
+	// ErrDownstreamAuth is returned when credentials for authenticating to the downstream cannot be set up
+	ErrDownstreamAuth = errors.New("downstream authentication setup failed")
+)
+
+// IsAuthError reports whether err is a credential or permission failure returned by the Pub/Sub API
+func IsAuthError(err error) bool {
+	switch status.Code(err) {
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return true
+	}
+	return false
+}
+
+// Config holds the forwarder configuration
+type Config struct {
+	Project      string
+	Subscription string
+	URL          string
+	// Sinks are the destinations each message is delivered to, defaulting to a single HTTP sink for URL
+	Sinks        []SinkSpec
+	KafkaBrokers []string
+	KafkaTopic   string
+	// OutputFile is the path the file sink writes JSON lines to
+	OutputFile string
+	// RotateSize rotates the output file once it reaches this many bytes, 0 disables size based rotation
+	RotateSize int64
+	// RotateInterval rotates the output file once it has been open this long, 0 disables time based rotation
+	RotateInterval time.Duration
+	// ExecCommand is the shell command the exec sink runs per message with the payload on stdin
+	ExecCommand string
+	// ExecConcurrency bounds how many exec sink processes run at once
+	ExecConcurrency int
+	// EventHubsConnectionString is the namespace connection string the eventhubs sink authenticates with
+	EventHubsConnectionString string
+	// EventHubsName is the Event Hub the eventhubs sink sends to, defaulting to the connection string's EntityPath
+	EventHubsName string
+	// KeepAttributes limits the forwarded attributes to this set when non-empty
+	KeepAttributes []string
+	// HeartbeatInterval enables a periodic liveness log when greater than zero
+	HeartbeatInterval time.Duration
+	// StartupProbeTimeout enables a retrying reachability check of the URL before consuming when greater than zero
+	StartupProbeTimeout time.Duration
+	// MaxMessageAge Acks and drops messages published longer ago than this when greater than zero
+	MaxMessageAge time.Duration
+	// IncludeSubscriptionID adds the short subscription name to the payload
+	IncludeSubscriptionID bool
+	// Format selects the request body encoding: json or multipart
+	Format string
+	// DropOnAttributeName and DropOnAttributeValue mark best-effort messages that are Acked instead of Nacked on failure
+	DropOnAttributeName  string
+	DropOnAttributeValue string
+	// MaxInflightBytes caps the total size of messages being delivered at once when greater than zero
+	MaxInflightBytes int64
+	// SchemaFile is a JSON Schema that JSON message data must satisfy before it is forwarded
+	SchemaFile string
+	// SchemaInvalidAction is what happens to messages that fail schema validation, deadletter, drop or nack
+	SchemaInvalidAction string
+	// DeadLetterTopic is the topic ID in the project that undeliverable messages are republished to
+	DeadLetterTopic string
+	// AdminAddr is the listen address of the admin HTTP server exposing /metrics, empty disables it
+	AdminAddr string
+	// CreateSubscription creates the subscription on Topic when it does not exist
+	CreateSubscription bool
+	Topic              string
+	AckDeadline        time.Duration
+	// TimeoutFromDeadline bounds each POST by the handler context deadline instead of the fixed timeout
+	TimeoutFromDeadline bool
+	// OrderedWorkers delivers messages on a fixed pool of workers with ordering keys hashed to a worker when greater than zero
+	OrderedWorkers int
+	// AttributesHeader is the name of a request header carrying the base64-encoded JSON attributes, empty disables it
+	AttributesHeader string
+	// NackDelay holds a failed message for this long before Nacking it to delay redelivery when greater than zero
+	NackDelay time.Duration
+	// Chunked sends the request body with chunked transfer encoding instead of a Content-Length
+	Chunked bool
+	// MaxResponseBytes is the most of each response body read before closing it so connections can be reused
+	MaxResponseBytes int64
+	// IncludeSubscriptionLabels adds the subscription's labels, fetched once at startup, to the payload
+	IncludeSubscriptionLabels bool
+	// AlertWebhook receives a single alert POST after AlertFailureThreshold consecutive failures and a recovery alert afterwards
+	AlertWebhook          string
+	AlertFailureThreshold int
+	// Pretty indents the JSON payload for human readability instead of the compact default
+	Pretty bool
+	// DeadlineHeader is the name of a request header carrying the time the POST will be abandoned, empty disables it
+	DeadlineHeader string
+	// DeadlineHeaderFormat formats the deadline header as rfc3339 or epoch seconds
+	DeadlineHeaderFormat string
+	// Accept is sent as the Accept header of each POST when set
+	Accept string
+	// RequireResponseField must be present in the JSON response body, and equal RequireResponseValue when that is set,
+	// for a 2xx response to be treated as success
+	RequireResponseField string
+	RequireResponseValue string
+	// MaxDeliveryAttempts dead-letters a message after this many failed deliveries counted in memory, 0 disables it
+	MaxDeliveryAttempts int
+	// TLSServerName overrides the server name used for SNI and certificate verification of https URLs
+	TLSServerName string
+	// FailoverURL receives the POST when delivery to the primary URL fails, empty disables failover
+	FailoverURL string
+	// PauseMode is how messages are handled while paused through the admin server: hold or nack
+	PauseMode string
+	// AutoConcurrency adapts the number of concurrent deliveries to 429 and 503 responses, up to AutoConcurrencyMax
+	AutoConcurrency    bool
+	AutoConcurrencyMax int
+	// MaxConnsPerHost caps the connections to each downstream host, 0 means no limit
+	MaxConnsPerHost int
+	// SampleRate is the fraction of messages forwarded, the rest are Acked without delivery
+	SampleRate float64
+	// SampleDeterministic samples by a hash of the message ID instead of randomly
+	SampleDeterministic bool
+	// DrainTimeout lets in-flight deliveries finish for this long on shutdown before the unfinished messages are
+	// Nacked, 0 cancels in-flight deliveries immediately
+	DrainTimeout time.Duration
+	// Headers are set on each POST, with values rendered from the message
+	Headers []Header
+	// AlwaysIncludeOrderingKey serializes orderingKey as an empty string instead of omitting it when not set
+	AlwaysIncludeOrderingKey bool
+	// ProxyURL routes every POST through an http, https or socks5 proxy instead of the environment's proxy settings
+	ProxyURL *url.URL
+	// ProxyHonorNoProxy bypasses ProxyURL for hosts listed in the NO_PROXY environment variable
+	ProxyHonorNoProxy bool
+	// PerKeyRateLimit is the most messages per second delivered for each ordering key, 0 disables it
+	PerKeyRateLimit float64
+	// PerKeyBurst is how many messages of one ordering key may be delivered at once above the rate
+	PerKeyBurst int
+	// SkipExistenceCheck starts receiving without verifying the subscription exists, which requires only
+	// pubsub.subscriptions.consume rather than pubsub.subscriptions.get
+	SkipExistenceCheck bool
+	// TransformWASM is the path of a WebAssembly module that rewrites the HTTP request for each message
+	TransformWASM string
+	// TransformTimeout bounds each invocation of the WASM transform
+	TransformTimeout time.Duration
+	// DownstreamHangTimeout fails a POST when no response headers arrive within it after the request is sent,
+	// 0 leaves only the overall request timeout
+	DownstreamHangTimeout time.Duration
+	// SeekToTime repositions the subscription to this time before consuming so messages are replayed, zero disables it
+	SeekToTime time.Time
+	// Compression encodes the request body as none, gzip, deflate or br and sets the matching Content-Encoding
+	Compression string
+	// InstanceID is sent as the X-Forwarder-Instance header so requests can be traced to the sending instance
+	InstanceID string
+	// ReorderWindow holds messages for up to this long to forward them in publish time order, 0 disables it
+	ReorderWindow time.Duration
+	// ReorderBufferSize is the most messages held in the reorder buffer
+	ReorderBufferSize int
+	// AuditLogFile receives a hash-chained JSON record of every forward attempt, empty disables auditing
+	AuditLogFile string
+	// KeepAlivePingInterval sends a HEAD request to KeepAlivePingPath on each HTTP downstream this often, 0 disables it
+	KeepAlivePingInterval time.Duration
+	KeepAlivePingPath     string
+	// DiskBufferDir Acks messages that fail delivery once they are written to this directory and redelivers them
+	// when the downstream recovers, empty disables the disk buffer
+	DiskBufferDir string
+	// DiskBufferMaxBytes bounds the disk buffer, messages that do not fit are Nacked
+	DiskBufferMaxBytes int64
+	// DiskBufferRetryInterval is how often redelivery of the disk buffer is attempted
+	DiskBufferRetryInterval time.Duration
+	// URLFromAttribute names an attribute whose value, when present, replaces the HTTP sink URL for the message
+	URLFromAttribute string
+	// AllowedHosts are the only hosts a URL taken from URLFromAttribute may target
+	AllowedHosts []string
+	// OnEmptyData is how messages without data are handled: forward, drop or deadletter
+	OnEmptyData string
+	// EmptyDataPlaceholder replaces empty data when forwarding, empty forwards the data as is
+	EmptyDataPlaceholder string
+	// TransformWorkers and SendWorkers split handling into a pool of transform workers feeding a pool of send
+	// workers, 0 transform workers handles each message in a single goroutine
+	TransformWorkers int
+	SendWorkers      int
+	// ReadinessCheckInterval is how often /readyz verifies the subscription is reachable, 0 disables the check
+	ReadinessCheckInterval time.Duration
+	// ReadinessFailureThreshold is how many consecutive failed checks make /readyz report not ready
+	ReadinessFailureThreshold int
+	// LogRedeliveries logs the delivery attempt of every message Pub/Sub has delivered before
+	LogRedeliveries bool
+	// CredentialsFile is an optional service account key file tried before the environment and ADC
+	CredentialsFile string
+	// OrderRetryPolicy is what happens to an ordering key after a message on it fails, skip or halt
+	OrderRetryPolicy string
+	// AdminExposeConfig serves the effective configuration with secrets redacted at /config on the admin server
+	AdminExposeConfig bool
+	// DeliverAfterAttribute names an attribute holding the earliest time a message may be forwarded
+	DeliverAfterAttribute string
+	// MaxDelay is the furthest in the future a scheduled message is held, later ones are Nacked
+	MaxDelay time.Duration
+	// GCSCompression compresses objects written by the gcs sink, none or gzip
+	GCSCompression string
+	// MaxLifetime shuts the process down gracefully after this long so the orchestrator restarts it, 0 disables it
+	MaxLifetime time.Duration
+	// ContentDigest adds a digest of the request body to each POST, md5 as Content-MD5 or sha256 as Digest
+	ContentDigest string
+	// MinExtensionPeriod is the shortest single ack deadline extension, 0 leaves the client library default
+	MinExtensionPeriod time.Duration
+	// MaxExtensionPeriod is the longest single ack deadline extension, 0 leaves the client library default
+	MaxExtensionPeriod time.Duration
+	// CookieJar keeps cookies set by HTTP downstreams and sends them on later POSTs
+	CookieJar bool
+	// LoginURL is posted the login form at startup and after a 401 to start a session, implying CookieJar
+	LoginURL string
+	// LoginUsername is the username field of the login form
+	LoginUsername string
+	// LoginPasswordFile holds the password field of the login form
+	LoginPasswordFile string
+	// EnrichmentFile is a JSON or CSV file mapping values of EnrichmentAttribute to fields added to messages
+	EnrichmentFile string
+	// EnrichmentAttribute is the attribute whose value is looked up in EnrichmentFile
+	EnrichmentAttribute string
+	// EnrichmentTarget is where enrichment fields are added, payload or headers
+	EnrichmentTarget string
+	// EnrichmentMissing is what happens to messages without an enrichment entry, pass or drop
+	EnrichmentMissing string
+	// NormalizeAttributeKeys converts forwarded attribute keys to lower, upper, kebab or snake case
+	NormalizeAttributeKeys string
+	// ExpiryAttribute names an attribute holding the time after which a message is dropped instead of forwarded
+	ExpiryAttribute string
+	// ExpiryMalformed is what happens to messages with an unparseable expiry, forward or drop
+	ExpiryMalformed string
+	// LagMonitoringInterval is how often the oldest unacked message age is fetched from Cloud Monitoring, 0 disables it
+	LagMonitoringInterval time.Duration
+	// MinInterval is the least time between the starts of consecutive deliveries, 0 disables it
+	MinInterval time.Duration
+	// CorrelationIDSource locates the ID sent in CorrelationIDHeader
+	CorrelationIDSource CorrelationSource
+	// CorrelationIDHeader is the HTTP header the correlation ID is sent in, empty disables it
+	CorrelationIDHeader string
+	// CorrelationIDFallback is what is sent when the source yields no ID, messageid or none
+	CorrelationIDFallback string
+	// OnPayloadTooLarge is what happens to messages the downstream rejects with 413, deadletter or drop
+	OnPayloadTooLarge string
+	// DrainNackWaiting Nacks messages that are waiting rather than being delivered as soon as shutdown begins
+	DrainNackWaiting bool
+	// FlattenAttributes promotes attributes to top-level payload fields instead of nesting them
+	FlattenAttributes bool
+	// FlattenAttributesPrefix is prepended to the field name of each flattened attribute
+	FlattenAttributesPrefix string
+	// RetryMaxDelay caps the delay a Retry-After header can ask for before a sink retry
+	RetryMaxDelay time.Duration
+	// MaxRetries is how many more times a failed send is attempted by sinks without a retries option
+	MaxRetries int
+	// RetryBaseDelay is the first retry delay of sinks without a backoff option, 0 uses the default of 500ms
+	RetryBaseDelay time.Duration
+	// RetryTotalTimeout bounds the time each sink spends on a message across its attempts, 0 for no bound
+	RetryTotalTimeout time.Duration
+
+	// WorkBufferSize is the number of received messages that can wait for a handler, zero disables the buffer
+	WorkBufferSize int
+	// WorkBufferOverflow is what happens to a message received while the work buffer is full, block or nack
+	WorkBufferOverflow string
+
+	// MethodFromAttribute names an attribute whose value, when present, is the HTTP method for the message
+	MethodFromAttribute string
+	// AllowedMethods are the only methods MethodFromAttribute may select
+	AllowedMethods []string
+	// InvalidMethodAction is what happens to a message whose method is not allowed, default or deadletter
+	InvalidMethodAction string
+
+	// SigningSecret is the key of the HMAC-SHA256 signature of each request body, empty disables signing
+	SigningSecret string
+	// SignatureScheme is the format of the signature header: generic, stripe or github
+	SignatureScheme string
+	// SignatureHeader overrides the name of the signature header, by default the one the scheme uses
+	SignatureHeader string
+
+	// MemoryLimit is the soft memory limit the process runs under, zero leaves memory unbounded
+	MemoryLimit int64
+
+	// SubscriptionHeader names a request header carrying the source subscription, empty disables it
+	SubscriptionHeader string
+	// SubscriptionHeaderFormat is short for the subscription ID or full for its resource path
+	SubscriptionHeaderFormat string
+
+	// ParseJSONAttributes names attributes whose values are JSON to embed as nested values in the payload
+	ParseJSONAttributes []string
+
+	// LastErrorsSize is the number of recent delivery failures served by /lasterrors, zero disables the endpoint
+	LastErrorsSize int
+
+	// RedactPaths are the JSON paths into the message data whose values are replaced by RedactMask
+	RedactPaths [][]any
+	// RedactMask is the string that replaces each redacted value
+	RedactMask string
+
+	// StartupJitter is the upper bound of a random delay before receiving starts
+	StartupJitter time.Duration
+
+	// DecodeFormat converts message data from avro or protobuf to JSON before forwarding, empty forwards it as is
+	DecodeFormat string
+	// DecodeSchema is the Avro schema file or Protobuf descriptor set file the data is decoded with
+	DecodeSchema string
+	// DecodeMessageType is the fully qualified Protobuf message type of the data
+	DecodeMessageType string
+	// DecodeSchemaRegistryURL is a Confluent compatible schema registry Avro schemas are fetched from by ID
+	DecodeSchemaRegistryURL string
+	// DecodeSchemaIDAttribute names the attribute holding the registry schema ID of the message
+	DecodeSchemaIDAttribute string
+
+	// BalanceTargets are equivalent URLs the HTTP sink spreads messages across by weight instead of URL
+	BalanceTargets []BalanceTarget
+	// BalanceStrategy chooses each message's target, weighted at random or round-robin
+	BalanceStrategy string
+
+	// MinTLSVersion is the lowest TLS version accepted from downstreams, a tls.Version constant
+	MinTLSVersion uint16
+
+	// KeySource locates the routing key of keyed sinks, the zero value uses the ordering key
+	KeySource CorrelationSource
+
+	// Version is the build version reported by the admin server's /info endpoint
+	Version string
+
+	// StateFile is a local file the delivery attempt counts are saved to so they survive restarts
+	StateFile string
+
+	// HTTP2StrictStreamLimit queues requests for a free stream once an HTTP/2 downstream's stream limit is
+	// reached, instead of opening another connection
+	HTTP2StrictStreamLimit bool
+	// HTTP2PingInterval sends a health check ping on an HTTP/2 connection idle for this long, zero disables it
+	HTTP2PingInterval time.Duration
+	// HTTP2PingTimeout closes an HTTP/2 connection whose ping is not answered within this long
+	HTTP2PingTimeout time.Duration
+
+	// IdleKeepAliveInterval is how long an HTTP sink may go without sending before IdleKeepAliveMessage is sent
+	IdleKeepAliveInterval time.Duration
+	// IdleKeepAliveMessage is the marker body POSTed to an idle HTTP sink with the X-Forwarder-Keepalive header
+	IdleKeepAliveMessage string
+
+	// AuthAudience attaches a Google-signed OIDC identity token for this audience to each request when set
+	AuthAudience string
+	// AuthServiceAccount is a service account impersonated to mint the AuthAudience tokens, empty to use the
+	// forwarder's own credentials
+	AuthServiceAccount string
+	// TokenRefreshMargin is how long before expiry an identity token is replaced, 0 for one minute
+	TokenRefreshMargin time.Duration
+
+	// identityTokens is the token source for AuthAudience, set by Run
+	identityTokens oauth2.TokenSource
+	// Subscriptions run one forwarder each with RunSubscriptions, set from a --config file
+	Subscriptions []SubscriptionConfig
+	// Routes send messages with a matching attribute to the route's URL instead of URL, set per subscription
+	// of a --config file
+	Routes []Route
+
+	// CaptureFile receives every received message as a JSON line for replaying with ReplayFile, empty disables it
+	CaptureFile string
+	// ReplayFile is a capture file whose messages Replay delivers without connecting to Pub/Sub
+	ReplayFile string
+	// ReplayMessageIDs limits Replay to these message IDs when set
+	ReplayMessageIDs []string
+
+	// logger receives the forwarder's log messages, set by RunSubscriptions to prefix them with the subscription
+	logger *log.Logger
+	// intervalGate, inflightLimit and concurrencyLimiter are the MinInterval, MaxInflightBytes and
+	// AutoConcurrency limits RunSubscriptions shares between its forwarders, nil for limits of their own
+	intervalGate *intervalGate
+	// adminGroup is the shared --admin-addr server this forwarder of a --config file reports to, if any
+	adminGroup         *adminGroup
+	inflightLimit      *semaphore.Weighted
+	concurrencyLimiter *aimdLimiter
+	// tlsConfig holds the TLS settings of a single http sink, set from its sink options
+	tlsConfig *tls.Config
+}
+
+// log returns the logger for the forwarder's messages, the standard logger unless one was set
+func (cfg *Config) log() *log.Logger {
+	if cfg.logger != nil {
+		return cfg.logger
+	}
+	return log.Default()
+}
+
+// PubSubMessage represents the transformed Pub/Sub message structure
+type PubSubMessage struct {
+	Message struct {
+		Attributes  map[string]string `json:"attributes"`
+		Data        string            `json:"data"`
+		MessageID   string            `json:"messageId"`
+		OrderingKey string            `json:"orderingKey,omitempty"`
+		PublishTime string            `json:"publishTime"`
+	} `json:"message"`
+	Subscription       string            `json:"subscription"`
+	SubscriptionID     string            `json:"subscriptionId,omitempty"`
+	SubscriptionLabels map[string]string `json:"subscriptionLabels,omitempty"`
+	Enrichment         map[string]string `json:"enrichment,omitempty"`
+
+	// body and headers replace the HTTP request body and add headers when a WASM transform rewrote the message
+	body    []byte
+	headers map[string]string
+	// url overrides the HTTP sink's URL when the message carries its own target in --url-from-attribute
+	url string
+	// method overrides POST when the message carries its own method in --method-from-attribute
+	method string
+	// key overrides the ordering key as the routing key of keyed sinks when --key-source yields one
+	key string
+}
+
+// routingKey returns the key keyed sinks such as Kafka partition by, the --key-source value or the ordering key
+func (p *PubSubMessage) routingKey() string {
+	if p.key != "" {
+		return p.key
+	}
+	return p.Message.OrderingKey
+}
+
+// Deliverer delivers a transformed message, the message is Acked when it returns nil and Nacked otherwise
+type Deliverer func(ctx context.Context, payload *PubSubMessage) error
+
+// Run consumes messages from the configured subscription until the context is cancelled, delivering each one
+// with deliverer. When deliverer is nil, messages are delivered to the sinks configured in cfg.Sinks.
+func Run(ctx context.Context, cfg *Config, deliverer Deliverer) error {
+	pause := &pauser{logger: cfg.log()}
+	ready := &readiness{threshold: int64(cfg.ReadinessFailureThreshold), logger: cfg.log()}
+	recent := newRecentErrors(cfg.LastErrorsSize)
+	if cfg.AdminAddr != "" {
+		startAdminServer(ctx, cfg, forwarderAdminHandlers(pause, ready, recent))
+	}
+	if cfg.adminGroup != nil {
+		cfg.adminGroup.join(adminMember{subscription: cfg.Subscription, pause: pause, ready: ready, recent: recent})
+	}
+
+	// Initialize Pub/Sub client and subscription
+	client, sub, err := setupPubSubClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			cfg.log().Printf("Error closing Pub/Sub client: %v", err)
+		}
+	}()
+
+	// Load the JSON schema so an invalid schema fails at startup rather than per message
+	var schema *jsonschema.Schema
+	if cfg.SchemaFile != "" {
+		schema, err = loadSchema(cfg.SchemaFile)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	transform, err := loadWASMTransform(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	defer transform.Close(context.Background())
+
+	dlq := newDeadLetterer(client, cfg)
+	defer dlq.Stop()
+
+	// Labels rarely change so they are fetched once rather than per message
+	var labels map[string]string
+	if cfg.IncludeSubscriptionLabels {
+		subCfg, err := sub.Config(ctx)
+		if err != nil {
+			return fmt.Errorf("%w: failed to fetch subscription labels: %w", ErrPubSubSetup, err)
+		}
+		labels = subCfg.Labels
+	}
+
+	// Mint identity tokens once for all requests rather than per message
+	if cfg.identityTokens, err = newIdentityTokenSource(ctx, cfg); err != nil {
+		return fmt.Errorf("%w: %w", ErrDownstreamAuth, err)
+	}
+
+	// Select the destination messages are delivered to
+	if deliverer == nil {
+		sinks, err := openSinks(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+		defer sinks.Close()
+
+		// Start the session of a session based downstream before the first delivery
+		if cfg.LoginURL != "" {
+			if err := sessionFor(cfg).login(ctx); err != nil {
+				return fmt.Errorf("%w: %w", ErrDownstreamUnreachable, err)
+			}
+		}
+
+		// Wait for HTTP downstreams to become reachable when a startup probe is configured
+		if cfg.StartupProbeTimeout > 0 {
+			for _, s := range sinks.sinks {
+				if h, ok := s.sink.(*httpSink); ok {
+					for _, url := range h.urls() {
+						if err := probeDownstream(ctx, url, cfg.StartupProbeTimeout, cfg.log()); err != nil {
+							return fmt.Errorf("%w: %w", ErrDownstreamUnreachable, err)
+						}
+					}
+				}
+			}
+		}
+
+		// Keep pooled connections to HTTP downstreams warm when pings are configured
+		if cfg.KeepAlivePingInterval > 0 {
+			for _, s := range sinks.sinks {
+				if h, ok := s.sink.(*httpSink); ok {
+					for _, url := range h.urls() {
+						go runKeepAlive(ctx, url, cfg)
+					}
+				}
+			}
+			if cfg.FailoverURL != "" {
+				go runKeepAlive(ctx, cfg.FailoverURL, cfg)
+			}
+		}
+
+		// Keep streams of downstreams that close idle streams open with marker messages when configured
+		if cfg.IdleKeepAliveInterval > 0 {
+			for _, s := range sinks.sinks {
+				if h, ok := s.sink.(*httpSink); ok {
+					h.lastSent.Store(time.Now().UnixNano())
+					go runIdleKeepAlive(ctx, h, h.cfg)
+				}
+			}
+		}
+		deliverer = sinks.Send
+	}
+
+	// Buffer failed messages to disk for redelivery when the downstream recovers
+	buffer, err := newDiskBuffer(cfg, deliverer)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if buffer != nil {
+		go buffer.run(ctx, cfg.DiskBufferRetryInterval)
+	}
+
+	// Parse the schema binary message data is decoded with
+	decoder, err := newDecoder(cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	// Load static reference data to enrich messages with, reloading it on SIGHUP
+	enricher, err := newEnricher(cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if enricher != nil {
+		go enricher.reloadOnHangup(ctx)
+	}
+
+	// Export the backlog age from Cloud Monitoring when enabled, which needs monitoring.viewer
+	if cfg.LagMonitoringInterval > 0 {
+		if err := runLagMonitor(ctx, cfg, cfg.LagMonitoringInterval); err != nil {
+			return fmt.Errorf("%w: %w", ErrPubSubSetup, err)
+		}
+	}
+
+	// Keep a copy of every received message for --replay when capturing
+	capture, err := openCaptureLog(cfg.CaptureFile, cfg.log())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	defer capture.Close()
+
+	// Log a periodic heartbeat when enabled
+	hb := newHeartbeat(cfg.log())
+	if cfg.HeartbeatInterval > 0 {
+		go hb.run(ctx, cfg.HeartbeatInterval)
+	}
+
+	// Start consuming messages
+	c := newConsumer(cfg, deliverer, hb, schema, dlq)
+	c.labels = labels
+	c.pause = pause
+	c.transform = transform
+	c.buffer = buffer
+	c.ready = ready
+	c.recent = recent
+	c.halts = newKeyHalter(cfg)
+	c.enricher = enricher
+	c.decoder = decoder
+	c.capture = capture
+	// The check only feeds /readyz, so it would cost metadata calls for nothing without the admin server
+	if cfg.ReadinessCheckInterval > 0 && !cfg.SkipExistenceCheck && (cfg.AdminAddr != "" || cfg.adminGroup != nil) {
+		go ready.run(ctx, sub, cfg.ReadinessCheckInterval)
+	}
+
+	// Keep delivery attempt counts across restarts, saving them a last time once receiving has stopped
+	if c.attempts != nil && cfg.StateFile != "" {
+		go c.attempts.persist(ctx, attemptStateSaveInterval)
+		defer c.attempts.save()
+	}
+
+	// Spread the start of replicas deployed together so they do not all begin pulling at once
+	if cfg.StartupJitter > 0 {
+		delay := rand.N(cfg.StartupJitter)
+		cfg.log().Printf("Delaying the start of receiving by %s", delay.Round(time.Millisecond))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+	return consumeMessages(ctx, sub, c)
+}
+
+// setupPubSubClient initializes the Pub/Sub client and subscription
+func setupPubSubClient(ctx context.Context, cfg *Config) (*pubsub.Client, *pubsub.Subscription, error) {
+	creds, err := findCredentials(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := pubsub.NewClient(ctx, cfg.Project, creds)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to create Pub/Sub client: %w", ErrPubSubSetup, err)
+	}
+
+	sub := client.Subscription(cfg.Subscription)
+	configureReceiveSettings(sub, cfg)
+	if cfg.SkipExistenceCheck {
+		cfg.log().Printf("Warning: skipping the existence check of subscription %s, it has not been verified", cfg.Subscription)
+		return client, sub, nil
+	}
+	exists, err := sub.Exists(ctx)
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("%w: failed to verify subscription existence: %w", ErrPubSubSetup, err)
+	}
+	if !exists && cfg.CreateSubscription {
+		cfg.log().Printf("Subscription %s does not exist, creating it on topic %s with ack deadline %s",
+			cfg.Subscription, cfg.Topic, cfg.AckDeadline)
+		sub, err = client.CreateSubscription(ctx, cfg.Subscription, pubsub.SubscriptionConfig{
+			Topic:       client.Topic(cfg.Topic),
+			AckDeadline: cfg.AckDeadline,
+		})
+		if err != nil {
+			client.Close()
+			return nil, nil, fmt.Errorf("%w: failed to create subscription %s: %w", ErrPubSubSetup, cfg.Subscription, err)
+		}
+		configureReceiveSettings(sub, cfg)
+		cfg.log().Printf("Created subscription: %s", cfg.Subscription)
+	} else if !exists {
+		client.Close()
+		return nil, nil, fmt.Errorf("%w: %s", ErrSubscriptionNotFound, cfg.Subscription)
+	}
+
+	if !cfg.SeekToTime.IsZero() {
+		cfg.log().Printf("WARNING: seeking subscription %s to %s, messages published since then, including already acknowledged ones, will be delivered again",
+			cfg.Subscription, cfg.SeekToTime.Format(time.RFC3339))
+		if err := sub.SeekToTime(ctx, cfg.SeekToTime); err != nil {
+			client.Close()
+			return nil, nil, fmt.Errorf("%w: failed to seek subscription %s: %w", ErrPubSubSetup, cfg.Subscription, err)
+		}
+	}
+
+	cfg.log().Printf("Connected to Pub/Sub subscription: %s", cfg.Subscription)
+	return client, sub, nil
+}
+
+// configureReceiveSettings applies the flow control settings for the configured processing mode
+func configureReceiveSettings(sub *pubsub.Subscription, cfg *Config) {
+	sub.ReceiveSettings.MaxOutstandingMessages = receiveConcurrency(cfg)
+	if cfg.WorkBufferSize > 0 {
+		if cfg.WorkBufferOverflow == workBufferNack {
+			// The work buffer is the bound, messages beyond it are Nacked as they arrive
+			sub.ReceiveSettings.MaxOutstandingMessages = -1
+		} else {
+			// Flow control stops pulling once the handlers are busy and the buffer is full
+			sub.ReceiveSettings.MaxOutstandingMessages += cfg.WorkBufferSize
+		}
+	}
+	if cfg.MemoryLimit > 0 {
+		// Keep the data pulled from the subscription to a quarter of the memory limit
+		sub.ReceiveSettings.MaxOutstandingBytes = int(min(cfg.MemoryLimit/memoryLimitOutstandingShare, int64(pubsub.DefaultReceiveSettings.MaxOutstandingBytes)))
+	}
+	// Bound each lease extension, zero keeps the client library's latency based choice
+	sub.ReceiveSettings.MinExtensionPeriod = cfg.MinExtensionPeriod
+	sub.ReceiveSettings.MaxExtensionPeriod = cfg.MaxExtensionPeriod
+}
+
+// memoryLimitOutstandingShare is the divisor of the memory limit that bounds the bytes pulled from the subscription
+const memoryLimitOutstandingShare = 4
+
+// receiveConcurrency returns the number of messages the configured processing mode handles at once
+func receiveConcurrency(cfg *Config) int {
+	if cfg.OrderedWorkers > 0 {
+		// Allow enough outstanding messages to keep every worker's queue full
+		return cfg.OrderedWorkers * orderedWorkerQueueDepth
+	} else if cfg.TransformWorkers > 0 {
+		// Pull enough messages to keep both stages and the channel between them busy
+		return cfg.TransformWorkers + 2*cfg.SendWorkers
+	} else if cfg.ReorderWindow > 0 {
+		// Pull enough messages to fill the reorder buffer
+		return cfg.ReorderBufferSize
+	} else if cfg.AutoConcurrency {
+		// Pull enough messages for the adaptive limit to reach its upper bound
+		return cfg.AutoConcurrencyMax
+	}
+	return 1
+}
+
+// transformMessage converts a Pub/Sub message into the desired JSON structure
+func transformMessage(msg *pubsub.Message, data []byte, cfg *Config) *PubSubMessage {
+	transformed := &PubSubMessage{}
+	transformed.Message.Attributes = filterAttributes(normalizeAttributeKeys(msg.Attributes, cfg.NormalizeAttributeKeys, cfg.log()), cfg.KeepAttributes)
+	if len(data) == 0 && cfg.EmptyDataPlaceholder != "" {
+		data = []byte(cfg.EmptyDataPlaceholder)
+	} else if len(cfg.RedactPaths) > 0 && len(data) > 0 {
+		data = redactData(data, cfg.RedactPaths, cfg.RedactMask, msg.ID, cfg.log())
+	}
+	transformed.Message.Data = base64.StdEncoding.EncodeToString(data)
+	transformed.Message.MessageID = msg.ID
+	transformed.Message.OrderingKey = msg.OrderingKey
+	transformed.Message.PublishTime = msg.PublishTime.Format(time.RFC3339)
+	transformed.Subscription = fmt.Sprintf("projects/%s/subscriptions/%s", cfg.Project, cfg.Subscription)
+	if cfg.IncludeSubscriptionID {
+		transformed.SubscriptionID = cfg.Subscription
+	}
+	return transformed
+}
+
+// filterAttributes returns only the allowlisted attributes, or all attributes when no allowlist is set
+func filterAttributes(attributes map[string]string, keep []string) map[string]string {
+	if len(keep) == 0 {
+		return attributes
+	}
+	filtered := make(map[string]string, len(keep))
+	for _, key := range keep {
+		if value, ok := attributes[key]; ok {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
+// probeDownstream retries a request to the URL with backoff until any HTTP response is received or the timeout elapses
+func probeDownstream(ctx context.Context, url string, timeout time.Duration, logger *log.Logger) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	delay := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create startup probe request: %w", err)
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			logger.Printf("Startup probe attempt %d reached %s. HTTP Status: %s", attempt, url, resp.Status)
+			return nil
+		}
+		logger.Printf("Startup probe attempt %d failed: %v", attempt, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("downstream %s unreachable after %s", url, timeout)
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, 10*time.Second)
+	}
+}
+
+// Backoff bounds for restarting Receive after a transient failure
+const (
+	maxReceiveBackoff = time.Minute
+	maxReceiveRetries = 10
+)
+
+// isTransientReceiveError reports whether a Receive failure is likely to clear on its own, such as a
+// metadata server hiccup during a token refresh. Permission denied indicates a bad IAM configuration and
+// is never retried.
+func isTransientReceiveError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Unauthenticated, codes.DeadlineExceeded, codes.Internal:
+		return true
+	}
+	return false
+}
+
+// consumer holds the state shared by the message handlers of a Receive loop
+type consumer struct {
+	cfg        *Config
+	deliver    Deliverer
+	hb         *heartbeat
+	schema     *jsonschema.Schema
+	dlq        *deadLetterer
+	inflight   *semaphore.Weighted
+	labels     map[string]string
+	alerts     *alerter
+	attempts   *attemptTracker
+	pause      *pauser
+	limiter    *aimdLimiter
+	keyLimiter *keyLimiter
+	interval   *intervalGate
+	transform  *wasmTransform
+	buffer     *diskBuffer
+	pipeline   *pipeline
+	ready      *readiness
+	halts      *keyHalter
+	enricher   *enricher
+	recent     *recentErrors
+	decoder    *decoder
+	capture    *captureLog
+	// replay is set by Replay, whose captured messages are meant to be resent however old they are and which
+	// has no subscription to redeliver a Nacked message
+	replay       bool
+	staleDropped atomic.Int64
+	// expiredDropped counts messages dropped for --expiry-attribute
+	expiredDropped atomic.Int64
+}
+
+// newInflightLimit returns the budget for --max-inflight-bytes, or nil when no budget is configured
+func newInflightLimit(cfg *Config) *semaphore.Weighted {
+	if cfg.MaxInflightBytes <= 0 {
+		return nil
+	}
+	if cfg.inflightLimit != nil {
+		return cfg.inflightLimit
+	}
+	return semaphore.NewWeighted(cfg.MaxInflightBytes)
+}
+
+// newConsumer creates the handler state for the configured processing options
+func newConsumer(cfg *Config, deliver Deliverer, hb *heartbeat, schema *jsonschema.Schema, dlq *deadLetterer) *consumer {
+	c := &consumer{
+		cfg:        cfg,
+		deliver:    deliver,
+		hb:         hb,
+		schema:     schema,
+		dlq:        dlq,
+		alerts:     newAlerter(cfg),
+		attempts:   newAttemptTracker(cfg),
+		limiter:    newAIMDLimiter(cfg),
+		keyLimiter: newKeyLimiter(cfg),
+		interval:   newIntervalGate(cfg),
+	}
+	c.inflight = newInflightLimit(cfg)
+	// Without a dead-letter topic, messages that would be dead-lettered are logged and Nacked
+	if c.dlq == nil {
+		c.dlq = &deadLetterer{subscription: cfg.Subscription, logger: cfg.log()}
+	}
+	return c
+}
+
+// handle processes a single message and records how long it took to be Acked or Nacked, logging the duration
+// of Nacked messages only since the histogram already covers the rest
+func (c *consumer) handle(ctx context.Context, msg *pubsub.Message) {
+	start := time.Now()
+	c.capture.record(msg)
+	c.hb.observe(msg.PublishTime)
+	messageLagSeconds.WithLabelValues(c.cfg.Subscription).Set(time.Since(msg.PublishTime).Seconds())
+	// DeliveryAttempt is only populated when the subscription has a dead letter policy
+	if msg.DeliveryAttempt != nil && *msg.DeliveryAttempt > 1 {
+		redeliveries.WithLabelValues(c.cfg.Subscription).Inc()
+		if c.cfg.LogRedeliveries {
+			c.cfg.log().Printf("Redelivered message ID %s: delivery attempt %d", msg.ID, *msg.DeliveryAttempt)
+		}
+	}
+	outcome := "nack"
+	if c.process(ctx, msg) {
+		outcome = "ack"
+	}
+	duration := time.Since(start)
+	handlerDuration.WithLabelValues(c.cfg.Subscription, outcome).Observe(duration.Seconds())
+	if outcome == "nack" {
+		c.cfg.log().Printf("Handled message ID %s: outcome=%s duration=%s", msg.ID, outcome, duration)
+	}
+}
+
+// process Acks or Nacks a single message, returning whether it was Acked
+func (c *consumer) process(ctx context.Context, msg *pubsub.Message) bool {
+	if !c.halts.admit(msg) {
+		c.cfg.log().Printf("Nacking message ID %s: ordering key %q is halted", msg.ID, msg.OrderingKey)
+		msg.Nack()
+		return false
+	}
+	if c.pipeline != nil {
+		return c.pipeline.run(ctx, msg)
+	}
+	transformed, acked := c.prepare(ctx, msg)
+	if transformed == nil {
+		return acked
+	}
+	return c.send(ctx, msg, transformed)
+}
+
+// prepare filters, validates and transforms a message. It returns the payload to deliver, or nil when the
+// message was already settled along with whether it was Acked.
+func (c *consumer) prepare(ctx context.Context, msg *pubsub.Message) (*PubSubMessage, bool) {
+	cfg := c.cfg
+	messageSizeBytes.WithLabelValues(cfg.Subscription).Observe(float64(len(msg.Data)))
+
+	// While paused, hold the message outstanding so its lease keeps being extended, or Nack it in nack mode
+	if !c.pause.hold(ctx, cfg.PauseMode) {
+		msg.Nack()
+		return nil, false
+	}
+
+	// Hold scheduled messages until their delivery time
+	if !c.waitUntilDue(ctx, msg) {
+		return nil, false
+	}
+
+	// Drop messages that are too old to be useful to the downstream
+	if cfg.MaxMessageAge > 0 && !c.replay {
+		if age := time.Since(msg.PublishTime); age > cfg.MaxMessageAge {
+			cfg.log().Printf("Dropping stale message ID %s published %s ago (%d stale messages dropped)",
+				msg.ID, age.Round(time.Second), c.staleDropped.Add(1))
+			c.hb.record()
+			msg.Ack()
+			return nil, true
+		}
+	}
+
+	// Drop messages whose publisher-set expiry has passed
+	if c.expired(msg) {
+		expiredDropped.WithLabelValues(cfg.Subscription).Inc()
+		cfg.log().Printf("Dropping expired message ID %s (%d expired messages dropped)", msg.ID, c.expiredDropped.Add(1))
+		c.hb.record()
+		msg.Ack()
+		return nil, true
+	}
+
+	// Forward only the sampled fraction of the stream
+	if !sampled(msg.ID, cfg) {
+		c.hb.record()
+		msg.Ack()
+		return nil, true
+	}
+
+	// Handle signal-only messages that carry attributes but no data
+	if len(msg.Data) == 0 {
+		switch cfg.OnEmptyData {
+		case "drop":
+			cfg.log().Printf("Dropping message ID %s with empty data", msg.ID)
+			c.hb.record()
+			msg.Ack()
+			return nil, true
+		case "deadletter":
+			c.hb.record()
+			return nil, c.dlq.handle(ctx, msg, "empty data")
+		}
+	}
+
+	// Transcode binary data so schema validation, transforms and the downstream see JSON
+	data := msg.Data
+	if c.decoder != nil && len(msg.Data) > 0 {
+		decoded, err := c.decoder.decode(ctx, msg)
+		if err != nil {
+			cfg.log().Printf("Error decoding message ID %s: %v", msg.ID, err)
+			c.hb.record()
+			// A registry outage is retried, while data that does not match its schema fails on every redelivery
+			if errors.Is(err, errSchemaUnavailable) {
+				c.nack(ctx, msg)
+				return nil, false
+			}
+			return nil, c.dlq.handle(ctx, msg, "decode failed: "+err.Error())
+		}
+		data = decoded
+	}
+
+	// Keep malformed events from reaching the downstream
+	if c.schema != nil {
+		if err := validateData(c.schema, data); err != nil {
+			cfg.log().Printf("Message ID %s failed schema validation: %v", msg.ID, err)
+			c.hb.record()
+			// A message failing validation fails again on every redelivery, so Nacking is only an explicit choice
+			switch {
+			case cfg.SchemaInvalidAction == "drop":
+				msg.Ack()
+				return nil, true
+			case cfg.SchemaInvalidAction == "nack":
+				c.nack(ctx, msg)
+				return nil, false
+			}
+			return nil, c.dlq.handle(ctx, msg, "schema validation failed")
+		}
+	}
+
+	transformed := transformMessage(msg, data, cfg)
+	transformed.SubscriptionLabels = c.labels
+	if c.enricher != nil && !c.enrich(transformed) {
+		cfg.log().Printf("Dropping message ID %s with no enrichment entry for %s", msg.ID, cfg.EnrichmentAttribute)
+		c.hb.record()
+		msg.Ack()
+		return nil, true
+	}
+	if cfg.CorrelationIDHeader != "" {
+		c.setCorrelationID(msg, transformed)
+	}
+	if key, ok := cfg.KeySource.extract(msg.Attributes, data); ok {
+		transformed.key = key
+	}
+	if target, ok := routeURL(cfg.Routes, msg.Attributes); ok {
+		transformed.url = target
+	}
+	if cfg.URLFromAttribute != "" {
+		if target, ok := msg.Attributes[cfg.URLFromAttribute]; ok {
+			if err := checkForwardURL(target, cfg.AllowedHosts); err != nil {
+				cfg.log().Printf("Message ID %s has a disallowed forward URL: %v", msg.ID, err)
+				return nil, c.dlq.handle(ctx, msg, "disallowed forward URL")
+			}
+			transformed.url = target
+		}
+	}
+	if cfg.MethodFromAttribute != "" {
+		if method, ok := msg.Attributes[cfg.MethodFromAttribute]; ok {
+			method = strings.ToUpper(strings.TrimSpace(method))
+			if slices.Contains(cfg.AllowedMethods, method) {
+				transformed.method = method
+			} else if cfg.InvalidMethodAction == "deadletter" {
+				cfg.log().Printf("Message ID %s has a disallowed HTTP method %q", msg.ID, method)
+				return nil, c.dlq.handle(ctx, msg, "disallowed HTTP method")
+			} else {
+				cfg.log().Printf("Message ID %s has a disallowed HTTP method %q, using POST", msg.ID, method)
+			}
+		}
+	}
+	if c.transform != nil {
+		if err := c.applyTransform(ctx, transformed); err != nil {
+			cfg.log().Printf("Error transforming message ID %s: %v", msg.ID, err)
+			c.nack(ctx, msg)
+			return nil, false
+		}
+	}
+	return transformed, false
+}
+
+// send paces and delivers a prepared message, then Acks or Nacks it based on the result, returning whether
+// it was Acked
+func (c *consumer) send(ctx context.Context, msg *pubsub.Message, transformed *PubSubMessage) bool {
+	cfg := c.cfg
+
+	// Wait for room in the in-flight byte budget, capping the weight so an oversized message can still proceed alone
+	if c.inflight != nil {
+		weight := min(int64(len(msg.Data)), cfg.MaxInflightBytes)
+		if err := c.inflight.Acquire(ctx, weight); err != nil {
+			msg.Nack()
+			return false
+		}
+		defer c.inflight.Release(weight)
+	}
+
+	// Hold a hot ordering key back to its rate, giving up if the wait would outlast the context deadline
+	if c.keyLimiter != nil {
+		if err := c.keyLimiter.wait(ctx, msg.OrderingKey); err != nil {
+			msg.Nack()
+			return false
+		}
+	}
+
+	// Space deliveries evenly for downstreams that dislike bursts
+	if c.interval != nil {
+		if err := c.interval.wait(ctx); err != nil {
+			msg.Nack()
+			return false
+		}
+	}
+
+	if c.limiter != nil {
+		if err := c.limiter.acquire(ctx); err != nil {
+			msg.Nack()
+			return false
+		}
+	}
+	err := c.deliver(ctx, transformed)
+	if c.limiter != nil {
+		c.limiter.release(err)
+	}
+	c.hb.record()
+	if err != nil {
+		c.alerts.failure(err)
+		var postErr *PostError
+		if errors.As(err, &postErr) {
+			cfg.log().Printf("Error processing message ID %s: status=%d latency=%s: %v",
+				msg.ID, postErr.StatusCode, postErr.Latency, err)
+		} else {
+			cfg.log().Printf("Error processing message ID %s: %v", msg.ID, err)
+		}
+		c.recent.record(msg.ID, err)
+		// Best-effort messages are dropped rather than redelivered
+		if cfg.DropOnAttributeName != "" {
+			if value, ok := msg.Attributes[cfg.DropOnAttributeName]; ok && value == cfg.DropOnAttributeValue {
+				cfg.log().Printf("Dropping best-effort message ID %s after failure", msg.ID)
+				c.halts.fail(msg, false)
+				msg.Ack()
+				return true
+			}
+		}
+		// A failure that retrying cannot fix is dead-lettered straight away
+		if isPermanent(err) {
+			if c.attempts != nil {
+				c.attempts.forget(msg.ID)
+			}
+			if cfg.OnPayloadTooLarge == "drop" && errors.As(err, &postErr) && postErr.StatusCode == http.StatusRequestEntityTooLarge {
+				cfg.log().Printf("Dropping message ID %s rejected as too large", msg.ID)
+				c.halts.fail(msg, false)
+				msg.Ack()
+				return true
+			}
+			reason := "non_retryable"
+			if errors.As(err, &postErr) && postErr.StatusCode == http.StatusRequestEntityTooLarge {
+				reason = "payload_too_large"
+			}
+			acked := c.dlq.handleOrDrop(ctx, msg, fmt.Sprintf("non-retryable failure: %v", err), reason)
+			c.halts.fail(msg, !acked)
+			return acked
+		}
+		// Give up on a message that keeps failing, preferring the server's count when a dead-letter policy provides one
+		if c.attempts != nil {
+			attempt := c.attempts.failure(msg.ID)
+			if msg.DeliveryAttempt != nil {
+				attempt = *msg.DeliveryAttempt
+			}
+			if attempt >= cfg.MaxDeliveryAttempts {
+				c.attempts.forget(msg.ID)
+				acked := c.dlq.handle(ctx, msg, fmt.Sprintf("failed %d delivery attempts", attempt))
+				c.halts.fail(msg, !acked)
+				return acked
+			}
+		}
+		// Trade the delivery guarantee for less redelivery churn by Acking once the message is buffered to disk
+		if c.buffer != nil {
+			bufferErr := c.buffer.store(transformed)
+			if bufferErr == nil {
+				cfg.log().Printf("Buffered message ID %s to disk for later redelivery", msg.ID)
+				c.halts.fail(msg, false)
+				msg.Ack()
+				return true
+			}
+			cfg.log().Printf("Error buffering message ID %s to disk: %v", msg.ID, bufferErr)
+		}
+		// Nack the message to allow redelivery
+		c.halts.fail(msg, true)
+		c.nack(ctx, msg)
+		return false
+	}
+	if c.attempts != nil {
+		c.attempts.forget(msg.ID)
+	}
+	c.halts.delivered(msg)
+	c.alerts.success()
+	// Acknowledge the message upon successful processing
+	msg.Ack()
+	return true
+}
+
+// checkForwardURL verifies a URL taken from a message attribute is an http or https URL on an allowed host, so
+// publishers cannot redirect traffic to arbitrary destinations
+func checkForwardURL(target string, allowedHosts []string) error {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", target, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid URL %q: must be an http or https URL with a host", target)
+	}
+	for _, host := range allowedHosts {
+		if strings.EqualFold(parsed.Hostname(), host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q of URL %q is not in the allowed hosts", parsed.Hostname(), target)
+}
+
+// applyTransform runs the WASM transform on the JSON payload and records the request body and headers it returns
+func (c *consumer) applyTransform(ctx context.Context, payload *PubSubMessage) error {
+	input, err := marshalPayload(payload, c.cfg)
+	if err != nil {
+		return err
+	}
+	output, err := c.transform.run(ctx, input)
+	if err != nil {
+		return err
+	}
+	payload.body = []byte(output.Body)
+	// Headers returned by the transform take precedence over enrichment headers
+	if payload.headers == nil {
+		payload.headers = make(map[string]string, len(output.Headers))
+	}
+	for name, value := range output.Headers {
+		payload.headers[name] = value
+	}
+	return nil
+}
+
+// nack Nacks a failed message, first holding it for the configured delay so redelivery is postponed. The
+// v1 client does not expose a per-message modify-ack-deadline, so the delay is implemented by keeping the
+// message outstanding while the client keeps extending its lease, then Nacking it. The wait ends early on
+// shutdown.
+func (c *consumer) nack(ctx context.Context, msg *pubsub.Message) {
+	if c.cfg.NackDelay > 0 && !c.replay {
+		timer := time.NewTimer(c.cfg.NackDelay)
+		defer timer.Stop()
+		select {
+		case <-waitDone(ctx):
+		case <-timer.C:
+		}
+	}
+	msg.Nack()
+}
+
+// consumeMessages continuously receives and processes Pub/Sub messages
+func consumeMessages(ctx context.Context, sub *pubsub.Subscription, c *consumer) error {
+	handler := c.handle
+	if c.cfg.OrderedWorkers > 0 {
+		pool := newOrderedPool(c.cfg.OrderedWorkers, c.handle)
+		defer pool.stop()
+		handler = pool.dispatch
+	} else if c.cfg.TransformWorkers > 0 {
+		c.pipeline = newPipeline(c, c.cfg.TransformWorkers, c.cfg.SendWorkers)
+		defer c.pipeline.stop()
+	} else if c.cfg.ReorderWindow > 0 {
+		reorder := newReorderBuffer(ctx, c.cfg.ReorderWindow, c.cfg.ReorderBufferSize, c.handle)
+		defer reorder.stop()
+		handler = reorder.add
+	}
+	if c.cfg.DrainTimeout > 0 {
+		d := newDrainer(ctx, c.cfg.DrainTimeout, c.cfg.DrainNackWaiting, c.cfg.log())
+		defer d.stop()
+		handler = d.wrap(handler)
+	}
+	if c.cfg.WorkBufferSize > 0 {
+		buffer := newWorkBuffer(ctx, c.cfg.Subscription, c.cfg.WorkBufferSize, receiveConcurrency(c.cfg), c.cfg.WorkBufferOverflow, handler)
+		defer buffer.stop()
+		handler = buffer.add
+	}
+
+	delay := time.Second
+	failures := 0
+	for {
+		started := time.Now()
+		c.ready.receiving.Store(true)
+		err := sub.Receive(ctx, handler)
+		c.ready.receiving.Store(false)
+		if err == nil || errors.Is(err, context.Canceled) || ctx.Err() != nil {
+			return nil
+		}
+		if !isTransientReceiveError(err) {
+			return fmt.Errorf("%w: %w", ErrReceive, err)
+		}
+
+		// A Receive loop that ran for a while before failing starts a fresh backoff sequence
+		if time.Since(started) > maxReceiveBackoff {
+			delay = time.Second
+			failures = 0
+		}
+		failures++
+		if failures > maxReceiveRetries {
+			return fmt.Errorf("%w after %d retries: %w", ErrReceive, maxReceiveRetries, err)
+		}
+
+		c.cfg.log().Printf("Transient error receiving messages, retrying in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReceiveBackoff)
+	}
+}
@@ -0,0 +1,164 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsTransientReceiveError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"token refresh failure", status.Error(codes.Unauthenticated, "metadata server unavailable"), true},
+		{"unavailable", status.Error(codes.Unavailable, "connection reset"), true},
+		{"deadline exceeded", status.Error(codes.DeadlineExceeded, "timeout"), true},
+		{"internal", status.Error(codes.Internal, "stream terminated"), true},
+		{"permission denied", status.Error(codes.PermissionDenied, "missing pubsub.subscriptions.consume"), false},
+		{"not found", status.Error(codes.NotFound, "subscription does not exist"), false},
+		{"wrapped token refresh failure", fmt.Errorf("receive: %w", status.Error(codes.Unauthenticated, "refresh failed")), true},
+		{"wrapped permission denied", fmt.Errorf("receive: %w", status.Error(codes.PermissionDenied, "denied")), false},
+		{"plain error", errors.New("something else"), false},
+		{"cancelled", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientReceiveError(tt.err); got != tt.want {
+				t.Errorf("isTransientReceiveError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareEmptyData(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		placeholder string
+		forwarded   bool
+		acked       bool
+		data        string
+	}{
+		{"forward", "forward", "", true, false, ""},
+		{"forward with placeholder", "forward", "{}", true, false, base64.StdEncoding.EncodeToString([]byte("{}"))},
+		{"drop", "drop", "", false, true, ""},
+		// Without a dead-letter topic the message is Nacked rather than lost
+		{"deadletter without a topic", "deadletter", "", false, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{SampleRate: 1, OnEmptyData: tt.mode, EmptyDataPlaceholder: tt.placeholder}
+			c := newConsumer(cfg, nil, newHeartbeat(log.Default()), nil, nil)
+			transformed, acked := c.prepare(context.Background(), &pubsub.Message{ID: "1", Attributes: map[string]string{"signal": "refresh"}})
+			if (transformed != nil) != tt.forwarded {
+				t.Fatalf("prepare() forwarded = %v, want %v", transformed != nil, tt.forwarded)
+			}
+			if transformed == nil {
+				if acked != tt.acked {
+					t.Errorf("prepare() acked = %v, want %v", acked, tt.acked)
+				}
+				return
+			}
+			if transformed.Message.Data != tt.data {
+				t.Errorf("data = %q, want %q", transformed.Message.Data, tt.data)
+			}
+		})
+	}
+}
+
+func TestPrepareMethodFromAttribute(t *testing.T) {
+	tests := []struct {
+		name      string
+		attribute string
+		action    string
+		forwarded bool
+		method    string
+	}{
+		{"allowed method", "put", "default", true, http.MethodPut},
+		{"allowed method with spaces", " Patch ", "default", true, http.MethodPatch},
+		{"disallowed method falls back to POST", "DELETE", "default", true, ""},
+		{"disallowed method is dead-lettered", "DELETE", "deadletter", false, ""},
+		{"invalid method is dead-lettered", "GET\r\nX-Injected: 1", "deadletter", false, ""},
+		{"no attribute", "", "deadletter", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				SampleRate:          1,
+				MethodFromAttribute: "http-method",
+				AllowedMethods:      []string{http.MethodPost, http.MethodPut, http.MethodPatch},
+				InvalidMethodAction: tt.action,
+			}
+			msg := &pubsub.Message{ID: "1", Data: []byte("{}"), Attributes: map[string]string{}}
+			if tt.attribute != "" {
+				msg.Attributes["http-method"] = tt.attribute
+			}
+			c := newConsumer(cfg, nil, newHeartbeat(log.Default()), nil, nil)
+			transformed, _ := c.prepare(context.Background(), msg)
+			if (transformed != nil) != tt.forwarded {
+				t.Fatalf("prepare() forwarded = %v, want %v", transformed != nil, tt.forwarded)
+			}
+			if transformed != nil && transformed.method != tt.method {
+				t.Errorf("method = %q, want %q", transformed.method, tt.method)
+			}
+		})
+	}
+}
+
+func TestSendDropsPayloadTooLargeWithoutDeadLetterTopic(t *testing.T) {
+	tooLarge := func(ctx context.Context, payload *PubSubMessage) error {
+		return permanent(&PostError{StatusCode: http.StatusRequestEntityTooLarge, Err: errors.New("too large")})
+	}
+	attempt := 3
+	tests := []struct {
+		name    string
+		attempt *int
+		acked   bool
+	}{
+		// Nacking would redeliver the message forever
+		{"without a dead-letter policy", nil, true},
+		// The server dead-letters the message once its Nacks reach the policy's limit
+		{"with a server-side dead-letter policy", &attempt, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Subscription: "orders", SampleRate: 1, OnPayloadTooLarge: "deadletter"}
+			c := newConsumer(cfg, tooLarge, newHeartbeat(log.Default()), nil, nil)
+			before := testutil.ToFloat64(undeliverableDropped.WithLabelValues("orders", "payload_too_large"))
+			acked := c.send(context.Background(), &pubsub.Message{ID: "1", DeliveryAttempt: tt.attempt}, &PubSubMessage{})
+			if acked != tt.acked {
+				t.Errorf("send() acked = %v, want %v", acked, tt.acked)
+			}
+			dropped := testutil.ToFloat64(undeliverableDropped.WithLabelValues("orders", "payload_too_large")) - before
+			if want := map[bool]float64{true: 1, false: 0}[tt.acked]; dropped != want {
+				t.Errorf("counted %g dropped messages, want %g", dropped, want)
+			}
+		})
+	}
+}
+
+func TestSendDropsPermanentFailureWithoutDeadLetterTopic(t *testing.T) {
+	rejected := func(ctx context.Context, payload *PubSubMessage) error {
+		return permanent(&PostError{StatusCode: http.StatusBadRequest, Err: errors.New("bad request")})
+	}
+	c := newConsumer(&Config{Subscription: "orders", SampleRate: 1}, rejected, newHeartbeat(log.Default()), nil, nil)
+	before := testutil.ToFloat64(undeliverableDropped.WithLabelValues("orders", "non_retryable"))
+	// A 400 fails on every redelivery, so a Nack would loop the message forever
+	if acked := c.send(context.Background(), &pubsub.Message{ID: "1"}, &PubSubMessage{}); !acked {
+		t.Error("send() Nacked a permanent failure without a dead-letter topic, want it Acked and dropped")
+	}
+	if dropped := testutil.ToFloat64(undeliverableDropped.WithLabelValues("orders", "non_retryable")) - before; dropped != 1 {
+		t.Errorf("counted %g dropped messages, want 1", dropped)
+	}
+}
@@ -0,0 +1,64 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"cloud.google.com/go/storage"
+)
+
+// gcsSink archives each transformed message as a JSON object in a Cloud Storage bucket
+type gcsSink struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+	prefix string
+	cfg    *Config
+}
+
+// newGCSSink creates a Cloud Storage client for archiving to the bucket under the object name prefix
+func newGCSSink(ctx context.Context, cfg *Config, bucket, prefix string) (*gcsSink, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Storage client: %w", err)
+	}
+
+	cfg.log().Printf("Archiving to Cloud Storage bucket: %s", bucket)
+	return &gcsSink{client: client, bucket: client.Bucket(bucket), prefix: prefix, cfg: cfg}, nil
+}
+
+// Send writes the message to an object named after its message ID, gzip compressed with a .gz suffix and a
+// gzip Content-Encoding when --gcs-compression is gzip
+func (g *gcsSink) Send(ctx context.Context, payload *PubSubMessage) error {
+	data, err := marshalPayload(payload, g.cfg)
+	if err != nil {
+		return err
+	}
+
+	name := path.Join(g.prefix, payload.Message.MessageID+".json")
+	contentEncoding := ""
+	if g.cfg.GCSCompression == "gzip" {
+		data, err = compressBody(data, "gzip")
+		if err != nil {
+			return err
+		}
+		name += ".gz"
+		contentEncoding = "gzip"
+	}
+	writer := g.bucket.Object(name).NewWriter(ctx)
+	writer.ContentType = "application/json"
+	writer.ContentEncoding = contentEncoding
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return fmt.Errorf("failed to write object %s: %w", name, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write object %s: %w", name, err)
+	}
+	return nil
+}
+
+// Close closes the Cloud Storage client
+func (g *gcsSink) Close() error {
+	return g.client.Close()
+}
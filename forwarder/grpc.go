@@ -0,0 +1,88 @@
+package forwarder
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// grpcSink calls a unary gRPC method with the JSON payload as a google.protobuf.BytesValue request, so any
+// service accepting that generic request can receive messages without an HTTP translation layer
+type grpcSink struct {
+	conn   *grpc.ClientConn
+	method string
+	cfg    *Config
+}
+
+// newGRPCSink creates a client for the target, connecting lazily and reconnecting with backoff as needed. The
+// connection uses TLS unless plaintext is set.
+func newGRPCSink(cfg *Config, target, method string, plaintext bool) (*grpcSink, error) {
+	creds := credentials.NewTLS(&tls.Config{ServerName: cfg.TLSServerName, MinVersion: cfg.MinTLSVersion})
+	if plaintext {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client for %s: %w", target, err)
+	}
+
+	cfg.log().Printf("Forwarding to gRPC method %s on %s", method, target)
+	return &grpcSink{conn: conn, method: method, cfg: cfg}, nil
+}
+
+// Send calls the method with the attributes as request metadata. Status codes that a retry cannot fix, such
+// as InvalidArgument, fail the message permanently so it is dead-lettered, other codes Nack it.
+func (g *grpcSink) Send(ctx context.Context, payload *PubSubMessage) error {
+	data, err := marshalPayload(payload, g.cfg)
+	if err != nil {
+		return err
+	}
+	payloadSizeBytes.WithLabelValues(g.cfg.Subscription).Observe(float64(len(data)))
+
+	md := metadata.MD{}
+	for key, value := range payload.Message.Attributes {
+		md.Append(grpcMetadataKey(key), value)
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+
+	if err := g.conn.Invoke(ctx, g.method, wrapperspb.Bytes(data), &emptypb.Empty{}); err != nil {
+		err = fmt.Errorf("gRPC call to %s failed: %w", g.method, err)
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.FailedPrecondition, codes.OutOfRange:
+			return permanent(err)
+		}
+		return err
+	}
+
+	g.cfg.log().Println("Message processed successfully.")
+	return nil
+}
+
+// grpcMetadataKey converts an attribute key to a valid metadata key, which must be lower case and may only
+// contain letters, digits, dashes, underscores and dots
+func grpcMetadataKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, key)
+}
+
+// Close closes the gRPC connection
+func (g *grpcSink) Close() error {
+	return g.conn.Close()
+}
@@ -0,0 +1,57 @@
+package forwarder
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// Header is a request header whose value may contain Go template placeholders evaluated per message
+type Header struct {
+	Name  string
+	Value *template.Template
+}
+
+// ParseHeader parses a header of the form "Name: value", where the value may reference the message as
+// {{.attributes.name}}, {{.messageId}}, {{.orderingKey}}, {{.publishTime}} or {{.subscription}}. A referenced
+// attribute that is missing renders as an empty string, or fails the message when strict is set.
+func ParseHeader(value string, strict bool) (Header, error) {
+	name, text, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return Header{}, fmt.Errorf("invalid header %q: must be Name: value", value)
+	}
+	missingKey := "missingkey=zero"
+	if strict {
+		missingKey = "missingkey=error"
+	}
+	tmpl, err := template.New(name).Option(missingKey).Parse(strings.TrimSpace(text))
+	if err != nil {
+		return Header{}, fmt.Errorf("invalid header %q: %w", value, err)
+	}
+	return Header{Name: name, Value: tmpl}, nil
+}
+
+// setHeaders evaluates each configured header against the message and sets it on the request
+func setHeaders(header http.Header, payload *PubSubMessage, cfg *Config) error {
+	if len(cfg.Headers) == 0 {
+		return nil
+	}
+
+	data := map[string]any{
+		"attributes":   payload.Message.Attributes,
+		"messageId":    payload.Message.MessageID,
+		"orderingKey":  payload.Message.OrderingKey,
+		"publishTime":  payload.Message.PublishTime,
+		"subscription": payload.Subscription,
+	}
+	for _, h := range cfg.Headers {
+		var value strings.Builder
+		if err := h.Value.Execute(&value, data); err != nil {
+			return fmt.Errorf("failed to render header %s: %w", h.Name, err)
+		}
+		header.Set(h.Name, value.String())
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package forwarder
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// heartbeat tracks processed messages and periodically logs a liveness line
+type heartbeat struct {
+	start     time.Time
+	processed atomic.Int64
+	// oldest is the earliest publish time in Unix nanoseconds among messages received since the previous
+	// heartbeat, 0 when none were received
+	oldest atomic.Int64
+	logger *log.Logger
+}
+
+// newHeartbeat creates a heartbeat with uptime measured from now, logging to logger
+func newHeartbeat(logger *log.Logger) *heartbeat {
+	return &heartbeat{start: time.Now(), logger: logger}
+}
+
+// record counts a message that has been Acked or Nacked
+func (h *heartbeat) record() {
+	h.processed.Add(1)
+}
+
+// observe notes the publish time of a received message for the backlog lag estimate
+func (h *heartbeat) observe(published time.Time) {
+	nanos := published.UnixNano()
+	for {
+		current := h.oldest.Load()
+		if current != 0 && current <= nanos {
+			return
+		}
+		if h.oldest.CompareAndSwap(current, nanos) {
+			return
+		}
+	}
+}
+
+// run logs the messages processed since the previous heartbeat at every interval until the context is
+// cancelled. When messages were received it also logs how far behind real time the oldest of them was and
+// whether that lag is shrinking, to show catch-up progress while working through a backlog.
+func (h *heartbeat) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previousLag time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			processed := h.processed.Swap(0)
+			h.logger.Printf("Heartbeat: %d messages processed in the last %s, uptime %s",
+				processed, interval, time.Since(h.start).Round(time.Second))
+
+			oldest := h.oldest.Swap(0)
+			if oldest == 0 {
+				continue
+			}
+			lag := time.Since(time.Unix(0, oldest)).Round(time.Second)
+			trend := "steady"
+			switch {
+			case previousLag == 0:
+				trend = "first estimate"
+			case lag < previousLag:
+				trend = "catching up"
+			case lag > previousLag:
+				trend = "falling behind"
+			}
+			h.logger.Printf("Backlog: oldest message received %s behind real time (%s, was %s), processing %.1f messages/s",
+				lag, trend, previousLag, float64(processed)/interval.Seconds())
+			previousLag = lag
+		}
+	}
+}
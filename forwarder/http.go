@@ -0,0 +1,476 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// PostError describes a failed POST with the detail needed to log and act on the failure
+type PostError struct {
+	// StatusCode is the HTTP status code returned, or 0 if no response was received
+	StatusCode int
+	// Latency is the time between sending the request and the response or transport failure
+	Latency time.Duration
+	// RetryAfter is the delay a 429 or 503 response asked for in its Retry-After header, 0 when absent
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *PostError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PostError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPDeliverer returns the default Deliverer, which POSTs each message to cfg.URL
+func HTTPDeliverer(cfg *Config) Deliverer {
+	return func(ctx context.Context, payload *PubSubMessage) error {
+		return postWithFailover(ctx, cfg.URL, payload, cfg)
+	}
+}
+
+// postWithFailover POSTs the payload to url, falling back to the failover URL when that fails. Success on
+// either counts as delivered.
+func postWithFailover(ctx context.Context, url string, payload *PubSubMessage, cfg *Config) error {
+	err := sendPOST(ctx, url, payload, cfg)
+	if err == nil {
+		httpDeliveries.WithLabelValues(cfg.Subscription, "primary").Inc()
+		return nil
+	}
+	if cfg.FailoverURL == "" {
+		return err
+	}
+
+	cfg.log().Printf("POST to primary URL failed for message ID %s, trying failover URL: %v", payload.Message.MessageID, err)
+	if err := sendPOST(ctx, cfg.FailoverURL, payload, cfg); err != nil {
+		return fmt.Errorf("failover POST failed: %w", err)
+	}
+	httpDeliveries.WithLabelValues(cfg.Subscription, "failover").Inc()
+	return nil
+}
+
+// buildRequestBody encodes the payload in the configured format and returns the body with its content type
+func buildRequestBody(payload *PubSubMessage, cfg *Config) ([]byte, string, error) {
+	if payload.body != nil {
+		return payload.body, "application/json", nil
+	}
+	if cfg.Format == "multipart" {
+		return buildMultipartBody(payload)
+	}
+
+	jsonData, err := marshalPayload(payload, cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	return jsonData, "application/json", nil
+}
+
+// payloadWithOrderingKey is PubSubMessage with the orderingKey field always serialized, even when empty
+type payloadWithOrderingKey struct {
+	Message struct {
+		Attributes  map[string]string `json:"attributes"`
+		Data        string            `json:"data"`
+		MessageID   string            `json:"messageId"`
+		OrderingKey string            `json:"orderingKey"`
+		PublishTime string            `json:"publishTime"`
+	} `json:"message"`
+	Subscription       string            `json:"subscription"`
+	SubscriptionID     string            `json:"subscriptionId,omitempty"`
+	SubscriptionLabels map[string]string `json:"subscriptionLabels,omitempty"`
+	Enrichment         map[string]string `json:"enrichment,omitempty"`
+
+	body    []byte
+	headers map[string]string
+	url     string
+	method  string
+	key     string
+}
+
+// marshalPayload serializes the payload as compact JSON, or indented JSON when pretty printing is enabled
+func marshalPayload(payload *PubSubMessage, cfg *Config) ([]byte, error) {
+	var value any = payload
+	if cfg.AlwaysIncludeOrderingKey {
+		value = (*payloadWithOrderingKey)(payload)
+	}
+
+	var data []byte
+	var err error
+	if cfg.FlattenAttributes || len(cfg.ParseJSONAttributes) > 0 {
+		fields, err := payloadFields(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal JSON payload: %w", err)
+		}
+		if len(cfg.ParseJSONAttributes) > 0 {
+			parseJSONAttributes(fields, cfg.ParseJSONAttributes, payload.Message.MessageID, cfg.log())
+		}
+		if cfg.FlattenAttributes {
+			flattenAttributes(fields, cfg.FlattenAttributesPrefix, payload.Message.MessageID, cfg.log())
+		}
+		value = fields
+	}
+	if cfg.Pretty {
+		data, err = json.MarshalIndent(value, "", "  ")
+	} else {
+		data, err = json.Marshal(value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON payload: %w", err)
+	}
+	return data, nil
+}
+
+// buildMultipartBody encodes the decoded message data as a file part named after the message ID and
+// each attribute as a text field
+func buildMultipartBody(payload *PubSubMessage) ([]byte, string, error) {
+	data, err := base64.StdEncoding.DecodeString(payload.Message.Data)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode message data: %w", err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range payload.Message.Attributes {
+		if err := writer.WriteField(key, value); err != nil {
+			return nil, "", fmt.Errorf("failed to write multipart field %s: %w", key, err)
+		}
+	}
+	part, err := writer.CreateFormFile("data", payload.Message.MessageID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create multipart file part: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, "", fmt.Errorf("failed to write multipart file part: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to close multipart body: %w", err)
+	}
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
+
+// defaultHTTPTimeout bounds each POST when neither a sink timeout nor a deadline derived timeout applies
+const defaultHTTPTimeout = 10 * time.Second
+
+// sinkTimeoutKey carries the timeout option of the sink sending the message in its context
+type sinkTimeoutKey struct{}
+
+// calledURLKey carries a pointer that each POST sets to the URL it sends to, so the audit record of an attempt
+// names the balance, route or failover URL actually called rather than the configured one
+type calledURLKey struct{}
+
+// requestTimeout returns the timeout for a POST, derived from the context deadline when enabled and present,
+// and otherwise the sink's timeout option or the default
+func requestTimeout(ctx context.Context, cfg *Config) time.Duration {
+	if cfg.TimeoutFromDeadline {
+		if deadline, ok := ctx.Deadline(); ok {
+			return time.Until(deadline)
+		}
+	}
+	if timeout, ok := ctx.Value(sinkTimeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return defaultHTTPTimeout
+}
+
+// transports holds the transport built for each config so connections are reused across POSTs
+var transports sync.Map
+
+// defaultTransport is the transport for configs without settings of their own, the default transport counting
+// HTTP/2 errors
+var defaultTransport = newTransport()
+
+// newTransport returns a clone of the default transport that counts HTTP/2 protocol errors by type
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.HTTP2 = &http.HTTP2Config{
+		CountError: func(errType string) {
+			http2Errors.WithLabelValues(errType).Inc()
+		},
+	}
+	return transport
+}
+
+// transportFor returns the shared transport for the config, applying its TLS and connection settings to a
+// clone of the default transport
+func transportFor(cfg *Config) http.RoundTripper {
+	// Go clients already require TLS 1.2, so only a higher minimum needs a transport of its own
+	if cfg.TLSServerName == "" && cfg.tlsConfig == nil && cfg.MinTLSVersion <= tls.VersionTLS12 && cfg.MaxConnsPerHost == 0 &&
+		cfg.ProxyURL == nil && cfg.DownstreamHangTimeout == 0 && !cfg.HTTP2StrictStreamLimit && cfg.HTTP2PingInterval == 0 {
+		return defaultTransport
+	}
+	if transport, ok := transports.Load(cfg); ok {
+		return transport.(http.RoundTripper)
+	}
+	transport := newTransport()
+	// Wait for a free stream instead of opening another connection once the downstream's stream limit is reached
+	transport.HTTP2.StrictMaxConcurrentRequests = cfg.HTTP2StrictStreamLimit
+	transport.HTTP2.SendPingTimeout = cfg.HTTP2PingInterval
+	transport.HTTP2.PingTimeout = cfg.HTTP2PingTimeout
+	transport.TLSClientConfig = &tls.Config{ServerName: cfg.TLSServerName, MinVersion: cfg.MinTLSVersion}
+	if cfg.tlsConfig != nil {
+		transport.TLSClientConfig = cfg.tlsConfig
+	}
+	transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	transport.ResponseHeaderTimeout = cfg.DownstreamHangTimeout
+	if cfg.ProxyURL != nil {
+		transport.Proxy = http.ProxyURL(cfg.ProxyURL)
+		if cfg.ProxyHonorNoProxy {
+			transport.Proxy = noProxyFunc(cfg.ProxyURL)
+		}
+	}
+	actual, _ := transports.LoadOrStore(cfg, transport)
+	return actual.(http.RoundTripper)
+}
+
+// noProxyFunc routes requests through proxy except for hosts excluded by the NO_PROXY environment variable
+func noProxyFunc(proxy *url.URL) func(*http.Request) (*url.URL, error) {
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  proxy.String(),
+		HTTPSProxy: proxy.String(),
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given as delay seconds or an HTTP date, returning 0 when the
+// header is absent, invalid or in the past
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
+
+// drainAndClose reads up to limit bytes of the response body before closing it so the keep-alive connection
+// can be reused, the limit keeps a large response from being read in full
+func drainAndClose(body io.ReadCloser, limit int64) {
+	if limit > 0 {
+		io.Copy(io.Discard, io.LimitReader(body, limit))
+	}
+	body.Close()
+}
+
+// checkResponseField parses the JSON response body, read up to the response limit, and verifies it contains the
+// required field with the required value, catching endpoints that return 200 with a failure body
+func checkResponseField(body io.Reader, cfg *Config) error {
+	var response map[string]any
+	decoder := json.NewDecoder(io.LimitReader(body, cfg.MaxResponseBytes))
+	// Keep numbers as written so large integer values compare exactly instead of through float64
+	decoder.UseNumber()
+	if err := decoder.Decode(&response); err != nil {
+		return fmt.Errorf("failed to parse response body: %w", err)
+	}
+	value, ok := response[cfg.RequireResponseField]
+	if !ok {
+		return fmt.Errorf("response body is missing required field %q", cfg.RequireResponseField)
+	}
+	if cfg.RequireResponseValue != "" && fmt.Sprint(value) != cfg.RequireResponseValue {
+		return fmt.Errorf("response field %q is %v, expected %s", cfg.RequireResponseField, value, cfg.RequireResponseValue)
+	}
+	return nil
+}
+
+// sendPOST sends the transformed message to the specified URL via HTTP POST, logging in again and retrying
+// once when a session based downstream responds 401
+func sendPOST(ctx context.Context, url string, payload *PubSubMessage, cfg *Config) error {
+	if called, ok := ctx.Value(calledURLKey{}).(*string); ok {
+		*called = url
+	}
+	started := time.Now()
+	err := postOnce(ctx, url, payload, cfg)
+	var postErr *PostError
+	if cfg.LoginURL == "" || !errors.As(err, &postErr) || postErr.StatusCode != http.StatusUnauthorized {
+		return err
+	}
+	if loginErr := sessionFor(cfg).relogin(ctx, started); loginErr != nil {
+		return fmt.Errorf("%w (re-login failed: %w)", err, loginErr)
+	}
+	return postOnce(ctx, url, payload, cfg)
+}
+
+// postOnce makes a single POST of the transformed message to the URL
+func postOnce(ctx context.Context, url string, payload *PubSubMessage, cfg *Config) error {
+	timeout := requestTimeout(ctx, cfg)
+	if timeout <= 0 {
+		return fmt.Errorf("message deadline expired before POST")
+	}
+
+	body, contentType, err := buildRequestBody(payload, cfg)
+	if err != nil {
+		return err
+	}
+
+	payloadSizeBytes.WithLabelValues(cfg.Subscription).Observe(float64(len(body)))
+
+	if cfg.Compression != "" && cfg.Compression != "none" {
+		body, err = compressBody(body, cfg.Compression)
+		if err != nil {
+			return err
+		}
+	}
+
+	method := http.MethodPost
+	if payload.method != "" {
+		method = payload.method
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if cfg.Compression != "" && cfg.Compression != "none" {
+		req.Header.Set("Content-Encoding", cfg.Compression)
+	}
+	if cfg.Accept != "" {
+		req.Header.Set("Accept", cfg.Accept)
+	}
+	if cfg.InstanceID != "" {
+		req.Header.Set("X-Forwarder-Instance", cfg.InstanceID)
+	}
+	if cfg.SubscriptionHeader != "" {
+		subscription := payload.Subscription
+		if cfg.SubscriptionHeaderFormat != "full" {
+			subscription = subscription[strings.LastIndex(subscription, "/")+1:]
+		}
+		req.Header.Set(cfg.SubscriptionHeader, subscription)
+	}
+	if err := setHeaders(req.Header, payload, cfg); err != nil {
+		return err
+	}
+	for name, value := range payload.headers {
+		req.Header.Set(name, value)
+	}
+	if err := setIdentityToken(req, cfg); err != nil {
+		return err
+	}
+	if cfg.Chunked {
+		// An unknown length makes the transport stream the body with Transfer-Encoding: chunked
+		req.ContentLength = -1
+		req.TransferEncoding = []string{"chunked"}
+	}
+	if cfg.DeadlineHeader != "" {
+		// The downstream can abandon work the forwarder will no longer wait for
+		deadline := time.Now().Add(timeout)
+		if cfg.DeadlineHeaderFormat == "epoch" {
+			req.Header.Set(cfg.DeadlineHeader, strconv.FormatInt(deadline.Unix(), 10))
+		} else {
+			req.Header.Set(cfg.DeadlineHeader, deadline.UTC().Format(time.RFC3339))
+		}
+	}
+	// The digest covers the exact bytes sent, after any compression
+	switch cfg.ContentDigest {
+	case "md5":
+		sum := md5.Sum(body)
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	case "sha256":
+		sum := sha256.Sum256(body)
+		req.Header.Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	if cfg.SigningSecret != "" {
+		// Like the digest the signature covers the exact bytes sent
+		signRequest(req.Header, body, cfg, time.Now())
+	}
+	if cfg.AttributesHeader != "" {
+		attributes, err := json.Marshal(payload.Message.Attributes)
+		if err != nil {
+			return fmt.Errorf("failed to marshal attributes header: %w", err)
+		}
+		req.Header.Set(cfg.AttributesHeader, base64.StdEncoding.EncodeToString(attributes))
+	}
+
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: transportFor(cfg),
+		Jar:       sessionFor(cfg).cookies(),
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			// A host that does not exist will not appear on a retry, unlike a resolver failure that is usually a
+			// transient blip
+			if dnsErr.IsNotFound {
+				return permanent(&PostError{Latency: latency, Err: fmt.Errorf("POST request failed: DNS lookup of %s failed (permanent, no such host): %w", dnsErr.Name, err)})
+			}
+			return &PostError{Latency: latency, Err: fmt.Errorf("POST request failed: DNS lookup of %s failed (transient): %w", dnsErr.Name, err)}
+		}
+		// Certificate problems need human intervention, so say exactly what is wrong
+		if description, ok := describeTLSError(err); ok {
+			httpTLSErrors.WithLabelValues(cfg.Subscription).Inc()
+			return &PostError{Latency: latency, Err: fmt.Errorf("POST request failed: TLS handshake failed, %s: %w", description, err)}
+		}
+		// A downstream that never sends response headers is reported apart from one that is slow overall
+		if cfg.DownstreamHangTimeout > 0 && strings.Contains(err.Error(), "timeout awaiting response headers") {
+			httpTimeouts.WithLabelValues(cfg.Subscription, "hang").Inc()
+			return &PostError{Latency: latency, Err: fmt.Errorf("POST request failed: downstream hung with no response headers within %s: %w", cfg.DownstreamHangTimeout, err)}
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			httpTimeouts.WithLabelValues(cfg.Subscription, "timeout").Inc()
+		}
+		return &PostError{Latency: latency, Err: fmt.Errorf("POST request failed: %w", err)}
+	}
+	defer drainAndClose(resp.Body, cfg.MaxResponseBytes)
+
+	// Retrying a body that is too large cannot succeed, so the message must not be redelivered
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		return permanent(&PostError{
+			StatusCode: resp.StatusCode,
+			Latency:    latency,
+			Err:        fmt.Errorf("downstream rejected the %d byte request body as too large. HTTP Status: %s", len(body), resp.Status),
+		})
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		postErr := &PostError{
+			StatusCode: resp.StatusCode,
+			Latency:    latency,
+			Err:        fmt.Errorf("failed to process message. HTTP Status: %s", resp.Status),
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			postErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return postErr
+	}
+	if cfg.RequireResponseField != "" {
+		if err := checkResponseField(resp.Body, cfg); err != nil {
+			return &PostError{StatusCode: resp.StatusCode, Latency: latency, Err: err}
+		}
+	}
+	cfg.log().Println("Message processed successfully.")
+
+	return nil
+}
@@ -0,0 +1,253 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receivedRequest is what the downstream of a test saw of a request
+type receivedRequest struct {
+	method           string
+	header           http.Header
+	body             []byte
+	contentLength    int64
+	transferEncoding []string
+}
+
+// sendToTestServer sends the payload to a test server with cfg and returns the request it received
+func sendToTestServer(t *testing.T, cfg *Config, payload *PubSubMessage) receivedRequest {
+	t.Helper()
+	var got receivedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = receivedRequest{method: r.Method, header: r.Header, body: body, contentLength: r.ContentLength, transferEncoding: r.TransferEncoding}
+	}))
+	defer server.Close()
+
+	cfg.URL = server.URL
+	if err := sendPOST(context.Background(), cfg.URL, payload, cfg); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestSendPOSTClassifiesDNSErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		dnsErr    *net.DNSError
+		permanent bool
+	}{
+		{"no such host", &net.DNSError{Err: "no such host", Name: "orders.invalid", IsNotFound: true}, true},
+		{"server misbehaving", &net.DNSError{Err: "server misbehaving", Name: "orders.invalid", IsTemporary: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A config with a transport setting gets its own transport, which the test replaces with one failing
+			// the lookup
+			cfg := &Config{URL: "http://orders.invalid/", MaxConnsPerHost: 1}
+			transports.Store(cfg, roundTripperFunc(func(*http.Request) (*http.Response, error) {
+				return nil, &net.OpError{Op: "dial", Net: "tcp", Err: tt.dnsErr}
+			}))
+			defer transports.Delete(cfg)
+
+			err := sendPOST(context.Background(), cfg.URL, &PubSubMessage{}, cfg)
+			var postErr *PostError
+			if !errors.As(err, &postErr) {
+				t.Fatalf("sendPOST() error = %v, want a PostError", err)
+			}
+			if isPermanent(err) != tt.permanent {
+				t.Errorf("isPermanent(%v) = %v, want %v", err, !tt.permanent, tt.permanent)
+			}
+		})
+	}
+}
+
+func TestSendPOSTChunked(t *testing.T) {
+	got := sendToTestServer(t, &Config{Chunked: true}, &PubSubMessage{})
+	if len(got.transferEncoding) != 1 || got.transferEncoding[0] != "chunked" {
+		t.Errorf("Transfer-Encoding = %v, want chunked", got.transferEncoding)
+	}
+	if got.contentLength != -1 || got.header.Get("Content-Length") != "" {
+		t.Errorf("Content-Length = %d, want none", got.contentLength)
+	}
+
+	got = sendToTestServer(t, &Config{}, &PubSubMessage{})
+	if len(got.transferEncoding) != 0 || got.contentLength != int64(len(got.body)) {
+		t.Errorf("unchunked request has Transfer-Encoding %v and Content-Length %d, want a length of %d",
+			got.transferEncoding, got.contentLength, len(got.body))
+	}
+}
+
+func TestCheckResponseFieldKeepsLargeIntegers(t *testing.T) {
+	// 2^53 + 1 is the first integer a float64 cannot hold, it would compare as 9007199254740992
+	body := `{"id": 9007199254740993}`
+	cfg := &Config{RequireResponseField: "id", RequireResponseValue: "9007199254740993", MaxResponseBytes: 1024}
+	if err := checkResponseField(strings.NewReader(body), cfg); err != nil {
+		t.Errorf("checkResponseField() error = %v, want the exact 64-bit ID to match", err)
+	}
+	cfg.RequireResponseValue = "9007199254740992"
+	if err := checkResponseField(strings.NewReader(body), cfg); err == nil {
+		t.Error("checkResponseField() matched the ID rounded through float64")
+	}
+}
+
+func TestTransportMaxConnsPerHost(t *testing.T) {
+	cfg := &Config{MaxConnsPerHost: 4}
+	defer transports.Delete(cfg)
+	transport, ok := transportFor(cfg).(*http.Transport)
+	if !ok {
+		t.Fatalf("transportFor() = %T, want *http.Transport", transportFor(cfg))
+	}
+	if transport.MaxConnsPerHost != 4 {
+		t.Errorf("MaxConnsPerHost = %d, want 4", transport.MaxConnsPerHost)
+	}
+	// Every routed host shares the transport, each host getting its own connection cap
+	if transportFor(cfg) != transport {
+		t.Error("transportFor() returned a new transport for the same config")
+	}
+	if defaultTransport.MaxConnsPerHost != 0 {
+		t.Errorf("default transport MaxConnsPerHost = %d, want no cap", defaultTransport.MaxConnsPerHost)
+	}
+}
+
+func TestMarshalPayloadOrderingKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		always      bool
+		orderingKey string
+		want        string
+		absent      bool
+	}{
+		{"omitted when empty", false, "", `"orderingKey"`, true},
+		{"kept when set", false, "customer-1", `"orderingKey":"customer-1"`, false},
+		{"always included when empty", true, "", `"orderingKey":""`, false},
+		{"always included when set", true, "customer-1", `"orderingKey":"customer-1"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := &PubSubMessage{}
+			payload.Message.OrderingKey = tt.orderingKey
+			data, err := marshalPayload(payload, &Config{AlwaysIncludeOrderingKey: tt.always})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(data), tt.want) == tt.absent {
+				t.Errorf("marshalPayload() = %s, want %s present %v", data, tt.want, !tt.absent)
+			}
+		})
+	}
+}
+
+func TestSendPOSTContentEncoding(t *testing.T) {
+	for _, algorithm := range []string{"gzip", "deflate", "br"} {
+		got := sendToTestServer(t, &Config{Compression: algorithm}, &PubSubMessage{})
+		if encoding := got.header.Get("Content-Encoding"); encoding != algorithm {
+			t.Errorf("Content-Encoding = %q, want %q", encoding, algorithm)
+		}
+	}
+	if got := sendToTestServer(t, &Config{Compression: "none"}, &PubSubMessage{}); got.header.Get("Content-Encoding") != "" {
+		t.Errorf("Content-Encoding = %q, want none", got.header.Get("Content-Encoding"))
+	}
+}
+
+func TestSendPOSTContentDigest(t *testing.T) {
+	// With compression the digest must cover the compressed bytes on the wire
+	for _, compression := range []string{"none", "gzip"} {
+		got := sendToTestServer(t, &Config{ContentDigest: "md5", Compression: compression}, &PubSubMessage{})
+		sum := md5.Sum(got.body)
+		if want := base64.StdEncoding.EncodeToString(sum[:]); got.header.Get("Content-MD5") != want {
+			t.Errorf("Content-MD5 with %s compression = %q, want %q", compression, got.header.Get("Content-MD5"), want)
+		}
+
+		got = sendToTestServer(t, &Config{ContentDigest: "sha256", Compression: compression}, &PubSubMessage{})
+		digest := sha256.Sum256(got.body)
+		if want := "sha-256=" + base64.StdEncoding.EncodeToString(digest[:]); got.header.Get("Digest") != want {
+			t.Errorf("Digest with %s compression = %q, want %q", compression, got.header.Get("Digest"), want)
+		}
+	}
+}
+
+func TestMarshalPayloadIsDeterministic(t *testing.T) {
+	newPayload := func() *PubSubMessage {
+		payload := &PubSubMessage{Enrichment: map[string]string{"tier": "gold", "region": "eu"}}
+		payload.Message.MessageID = "1"
+		payload.Message.Data = base64.StdEncoding.EncodeToString([]byte(`{"b":1,"a":2}`))
+		payload.Message.Attributes = map[string]string{}
+		for i := range 50 {
+			payload.Message.Attributes[fmt.Sprintf("attr-%02d", i)] = fmt.Sprint(i)
+		}
+		payload.Message.Attributes["metadata"] = `{"z":1,"y":{"x":2}}`
+		return payload
+	}
+	configs := map[string]*Config{
+		"default":               {},
+		"parse json attributes": {ParseJSONAttributes: []string{"metadata"}},
+		"flattened":             {FlattenAttributes: true, FlattenAttributesPrefix: "attr_", ParseJSONAttributes: []string{"metadata"}},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			// Map iteration order differs between runs, so repeat enough to catch any dependence on it
+			first, err := marshalPayload(newPayload(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 20 {
+				again, err := marshalPayload(newPayload(), cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(first, again) {
+					t.Fatalf("serializations differ:\n%s\n%s", first, again)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"", 0},
+		{"soon", 0},
+		{"-5", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSendPOSTUsesMessageMethod(t *testing.T) {
+	payload := &PubSubMessage{method: http.MethodPut}
+	if got := sendToTestServer(t, &Config{}, payload); got.method != http.MethodPut {
+		t.Errorf("method = %s, want PUT", got.method)
+	}
+	if got := sendToTestServer(t, &Config{}, &PubSubMessage{}); got.method != http.MethodPost {
+		t.Errorf("method = %s, want the default POST", got.method)
+	}
+}
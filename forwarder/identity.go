@@ -0,0 +1,126 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// newIdentityTokenSource returns a source of Google-signed OIDC identity tokens for --auth-audience, or nil
+// when no audience is configured. Tokens come from the --credentials-file service account key when set and
+// otherwise from Application Default Credentials, such as the metadata server on Google Cloud. With
+// --auth-service-account those credentials instead impersonate the service account through the IAM
+// Credentials API, like a push subscription's authentication. The source caches each token and mints a new
+// one once it is within cfg.TokenRefreshMargin of expiring.
+func newIdentityTokenSource(ctx context.Context, cfg *Config) (oauth2.TokenSource, error) {
+	if cfg.AuthAudience == "" {
+		return nil, nil
+	}
+	var opts []option.ClientOption
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, option.WithAuthCredentialsFile(option.ServiceAccount, cfg.CredentialsFile))
+	}
+
+	// Tokens are refreshed for as long as messages are sent, including while draining after ctx is cancelled
+	ctx = context.WithoutCancel(ctx)
+	margin := cfg.TokenRefreshMargin
+	if margin <= 0 {
+		margin = defaultTokenRefreshMargin
+	}
+	tokens := &identityTokenCache{
+		margin: margin,
+		logger: cfg.log(),
+		// The library sources cache their token until seconds before it expires, so each refresh starts a new
+		// one for the token to be minted when the margin asks for it
+		mint: func() (*oauth2.Token, error) {
+			var source oauth2.TokenSource
+			var err error
+			if cfg.AuthServiceAccount != "" {
+				source, err = impersonate.IDTokenSource(ctx, impersonate.IDTokenConfig{
+					Audience:        cfg.AuthAudience,
+					TargetPrincipal: cfg.AuthServiceAccount,
+					// Push subscriptions include the email claim, which receivers commonly check
+					IncludeEmail: true,
+				}, opts...)
+			} else {
+				source, err = idtoken.NewTokenSource(ctx, cfg.AuthAudience, opts...)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to create identity token source for audience %s: %w", cfg.AuthAudience, err)
+			}
+			token, err := source.Token()
+			if err != nil {
+				return nil, fmt.Errorf("failed to mint identity token for audience %s: %w", cfg.AuthAudience, err)
+			}
+			return token, nil
+		},
+	}
+	// Mint the first token now so missing permissions fail startup rather than every delivery
+	if _, err := tokens.Token(); err != nil {
+		return nil, err
+	}
+
+	if cfg.AuthServiceAccount != "" {
+		cfg.log().Printf("Attaching identity tokens of service account %s for audience %s", cfg.AuthServiceAccount, cfg.AuthAudience)
+	} else {
+		cfg.log().Printf("Attaching identity tokens for audience %s", cfg.AuthAudience)
+	}
+	return tokens, nil
+}
+
+// defaultTokenRefreshMargin is how long before expiry an identity token is replaced when no margin is configured
+const defaultTokenRefreshMargin = time.Minute
+
+// identityTokenCache reuses an identity token for every request until it is within margin of expiring, so the
+// token endpoint sees one request per token lifetime rather than one per message
+type identityTokenCache struct {
+	mint   func() (*oauth2.Token, error)
+	margin time.Duration
+	logger *log.Logger
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// Token returns the cached token, minting a new one when it is within the margin of expiring. A token that
+// fails to refresh keeps being used until it has actually expired.
+func (c *identityTokenCache) Token() (*oauth2.Token, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != nil && time.Until(c.token.Expiry) > c.margin {
+		return c.token, nil
+	}
+	token, err := c.mint()
+	if err != nil {
+		identityTokenRefreshes.WithLabelValues("failure").Inc()
+		if c.token != nil && time.Until(c.token.Expiry) > 0 {
+			c.logger.Printf("Failed to refresh identity token, using the current one until it expires at %s: %v", c.token.Expiry.Format(time.RFC3339), err)
+			return c.token, nil
+		}
+		return nil, err
+	}
+	identityTokenRefreshes.WithLabelValues("success").Inc()
+	c.token = token
+	return token, nil
+}
+
+// setIdentityToken sets the Authorization header to a current identity token when one is configured
+func setIdentityToken(req *http.Request, cfg *Config) error {
+	if cfg.identityTokens == nil {
+		return nil
+	}
+	token, err := cfg.identityTokens.Token()
+	if err != nil {
+		return fmt.Errorf("failed to get identity token for audience %s: %w", cfg.AuthAudience, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return nil
+}
@@ -0,0 +1,92 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestIdentityTokenHeader(t *testing.T) {
+	var got string
+	var present bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, present = r.Header["Authorization"]
+		got = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	t.Run("with an audience", func(t *testing.T) {
+		cfg := &Config{URL: server.URL, AuthAudience: "https://orders.example.com"}
+		cfg.identityTokens = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token-1"})
+		if err := sendPOST(context.Background(), cfg.URL, &PubSubMessage{}, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got != "Bearer token-1" {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer token-1")
+		}
+	})
+
+	t.Run("without an audience", func(t *testing.T) {
+		cfg := &Config{URL: server.URL}
+		tokens, err := newIdentityTokenSource(context.Background(), cfg)
+		if err != nil || tokens != nil {
+			t.Fatalf("newIdentityTokenSource() = %v, %v, want no token source", tokens, err)
+		}
+		cfg.identityTokens = tokens
+		if err := sendPOST(context.Background(), cfg.URL, &PubSubMessage{}, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if present {
+			t.Errorf("Authorization = %q, want no header", got)
+		}
+	})
+}
+
+func TestIdentityTokenCacheRefreshesWithinMargin(t *testing.T) {
+	var mints int
+	expiry := time.Now().Add(time.Hour)
+	tokens := &identityTokenCache{
+		margin: time.Minute,
+		logger: log.Default(),
+		mint: func() (*oauth2.Token, error) {
+			mints++
+			if mints == 3 {
+				return nil, errors.New("token endpoint unavailable")
+			}
+			return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", mints), Expiry: expiry}, nil
+		},
+	}
+
+	for range 3 {
+		if token, err := tokens.Token(); err != nil || token.AccessToken != "token-1" {
+			t.Fatalf("Token() = %v, %v, want the cached token-1", token, err)
+		}
+	}
+	if mints != 1 {
+		t.Errorf("minted %d tokens for three requests, want 1", mints)
+	}
+
+	// Within the margin of the expiry a new token is minted ahead of time
+	tokens.token.Expiry = time.Now().Add(30 * time.Second)
+	if token, err := tokens.Token(); err != nil || token.AccessToken != "token-2" {
+		t.Fatalf("Token() = %v, %v, want the refreshed token-2", token, err)
+	}
+
+	// A failed refresh keeps the current token while it is still valid, but not once it has expired
+	tokens.token.Expiry = time.Now().Add(30 * time.Second)
+	if token, err := tokens.Token(); err != nil || token.AccessToken != "token-2" {
+		t.Fatalf("Token() = %v, %v, want token-2 kept after the failed refresh", token, err)
+	}
+	mints = 2
+	tokens.token.Expiry = time.Now().Add(-time.Second)
+	if _, err := tokens.Token(); err == nil {
+		t.Error("Token() succeeded with an expired token and a failed refresh, want the error")
+	}
+}
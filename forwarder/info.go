@@ -0,0 +1,117 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// serviceInfo is the concise summary of the build and active modes served at /info. It names modes only and
+// never includes values such as URLs, topics or secrets.
+type serviceInfo struct {
+	Version           string   `json:"version"`
+	Sinks             []string `json:"sinks"`
+	Format            string   `json:"format"`
+	Compression       string   `json:"compression"`
+	Processing        string   `json:"processing"`
+	DownstreamAuth    []string `json:"downstreamAuth"`
+	PubSubCredentials string   `json:"pubsubCredentials"`
+	Features          []string `json:"features"`
+}
+
+// describeService summarizes which modes the configuration enables
+func describeService(cfg *Config) serviceInfo {
+	info := serviceInfo{
+		Version:           cfg.Version,
+		Sinks:             []string{},
+		Format:            cfg.Format,
+		Compression:       cfg.Compression,
+		Processing:        "serial",
+		DownstreamAuth:    []string{},
+		PubSubCredentials: "default",
+		Features:          []string{},
+	}
+	for _, spec := range cfg.Sinks {
+		info.Sinks = append(info.Sinks, spec.Type)
+		if spec.Type == "http" && spec.Options["cert"] != "" {
+			info.DownstreamAuth = append(info.DownstreamAuth, "client-certificate")
+		}
+	}
+
+	switch {
+	case cfg.OrderedWorkers > 0:
+		info.Processing = "ordered"
+	case cfg.TransformWorkers > 0:
+		info.Processing = "pipeline"
+	case cfg.ReorderWindow > 0:
+		info.Processing = "reorder"
+	case cfg.AutoConcurrency:
+		info.Processing = "auto-concurrency"
+	}
+
+	if cfg.LoginURL != "" {
+		info.DownstreamAuth = append(info.DownstreamAuth, "session-login")
+	}
+	if cfg.SigningSecret != "" {
+		info.DownstreamAuth = append(info.DownstreamAuth, "signature-"+cfg.SignatureScheme)
+	}
+	if cfg.AuthAudience != "" {
+		info.DownstreamAuth = append(info.DownstreamAuth, "oidc-identity-token")
+		if cfg.AuthServiceAccount != "" {
+			info.DownstreamAuth = append(info.DownstreamAuth, "impersonation")
+		}
+	}
+	if cfg.CredentialsFile != "" {
+		info.PubSubCredentials = "file"
+	}
+
+	features := []struct {
+		name    string
+		enabled bool
+	}{
+		{"audit-log", cfg.AuditLogFile != ""},
+		{"balance-" + cfg.BalanceStrategy, len(cfg.BalanceTargets) > 0},
+		{"capture", cfg.CaptureFile != ""},
+		{"correlation-id", cfg.CorrelationIDHeader != ""},
+		{"dead-letter", cfg.DeadLetterTopic != ""},
+		{"decode-" + cfg.DecodeFormat, cfg.DecodeFormat != ""},
+		{"deliver-after", cfg.DeliverAfterAttribute != ""},
+		{"disk-buffer", cfg.DiskBufferDir != ""},
+		{"drain", cfg.DrainTimeout > 0},
+		{"enrichment", cfg.EnrichmentFile != ""},
+		{"expiry", cfg.ExpiryAttribute != ""},
+		{"failover", cfg.FailoverURL != ""},
+		{"flatten-attributes", cfg.FlattenAttributes},
+		{"lag-monitoring", cfg.LagMonitoringInterval > 0},
+		{"memory-limit", cfg.MemoryLimit > 0},
+		{"method-from-attribute", cfg.MethodFromAttribute != ""},
+		{"min-interval", cfg.MinInterval > 0},
+		{"parse-json-attributes", len(cfg.ParseJSONAttributes) > 0},
+		{"per-key-rate-limit", cfg.PerKeyRateLimit > 0},
+		{"proxy", cfg.ProxyURL != nil},
+		{"redaction", len(cfg.RedactPaths) > 0},
+		{"sampling", cfg.SampleRate < 1},
+		{"schema-validation", cfg.SchemaFile != ""},
+		{"url-from-attribute", cfg.URLFromAttribute != ""},
+		{"wasm-transform", cfg.TransformWASM != ""},
+		{"work-buffer-" + cfg.WorkBufferOverflow, cfg.WorkBufferSize > 0},
+	}
+	for _, feature := range features {
+		if feature.enabled {
+			info.Features = append(info.Features, feature.name)
+		}
+	}
+	return info
+}
+
+// infoHandler serves the service summary as JSON, built once since the configuration does not change
+func infoHandler(cfg *Config) http.Handler {
+	body, err := json.MarshalIndent(describeService(cfg), "", "  ")
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
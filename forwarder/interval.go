@@ -0,0 +1,53 @@
+package forwarder
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// intervalGate spaces deliveries at least a fixed interval apart, turning bursts into an even stream. Unlike
+// a token bucket it never lets requests through back to back.
+type intervalGate struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newIntervalGate returns a gate for --min-interval, or nil when no interval is configured. The forwarders of a
+// --config file share one gate, so together they stay within the interval.
+func newIntervalGate(cfg *Config) *intervalGate {
+	if cfg.MinInterval <= 0 {
+		return nil
+	}
+	if cfg.intervalGate != nil {
+		return cfg.intervalGate
+	}
+	return &intervalGate{interval: cfg.MinInterval}
+}
+
+// wait reserves the next slot and blocks until it arrives, returning the context's error if it is cancelled
+// first
+func (g *intervalGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	slot := time.Now()
+	if g.next.After(slot) {
+		slot = g.next
+	}
+	g.next = slot.Add(g.interval)
+	g.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaSink produces transformed Pub/Sub messages to a Kafka topic
+type kafkaSink struct {
+	writer       *kafka.Writer
+	subscription string
+	logger       *log.Logger
+}
+
+// newKafkaSink creates a Kafka producer for the configured brokers and the topic, which defaults to cfg.KafkaTopic
+func newKafkaSink(cfg *Config, topic string) *kafkaSink {
+	if topic == "" {
+		topic = cfg.KafkaTopic
+	}
+	writer := &kafka.Writer{
+		Addr:     kafka.TCP(cfg.KafkaBrokers...),
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
+		// Wait for all in-sync replicas so an Ack is only sent once the message is durable
+		RequiredAcks: kafka.RequireAll,
+		// Each message is produced synchronously on its own, so waiting to fill a batch only adds latency
+		BatchSize: 1,
+		// Failed produces are retried by the sink's retries instead, rather than stacking the two
+		MaxAttempts: 1,
+	}
+
+	cfg.log().Printf("Producing to Kafka topic: %s", topic)
+	return &kafkaSink{writer: writer, subscription: cfg.Subscription, logger: cfg.log()}
+}
+
+// Send produces the message data to Kafka, mapping attributes to headers and
+// the routing key to the partition key
+func (k *kafkaSink) Send(ctx context.Context, payload *PubSubMessage) error {
+	data, err := base64.StdEncoding.DecodeString(payload.Message.Data)
+	if err != nil {
+		return fmt.Errorf("failed to decode message data: %w", err)
+	}
+
+	payloadSizeBytes.WithLabelValues(k.subscription).Observe(float64(len(data)))
+
+	msg := kafka.Message{
+		Value: data,
+	}
+	if key := payload.routingKey(); key != "" {
+		msg.Key = []byte(key)
+	}
+	for key, value := range payload.Message.Attributes {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+
+	if err := k.writer.WriteMessages(ctx, msg); err != nil {
+		return fmt.Errorf("failed to produce Kafka message: %w", err)
+	}
+
+	k.logger.Println("Message processed successfully.")
+	return nil
+}
+
+// Close flushes any pending writes and closes the Kafka producer
+func (k *kafkaSink) Close() error {
+	return k.writer.Close()
+}
@@ -0,0 +1,83 @@
+package forwarder
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// idleKeepAliveHeader marks the synthetic messages sent by runIdleKeepAlive so the downstream can ignore them
+const idleKeepAliveHeader = "X-Forwarder-Keepalive"
+
+// runKeepAlive sends a HEAD request to the ping path on the downstream's host every interval until the context
+// is cancelled. The pings go through the shared transport so its pooled connections stay warm during quiet
+// periods instead of being dropped as idle by load balancers.
+func runKeepAlive(ctx context.Context, target string, cfg *Config) {
+	pingURL, err := url.Parse(target)
+	if err != nil {
+		cfg.log().Printf("Keep-alive ping disabled for %s: %v", target, err)
+		return
+	}
+	pingURL.Path = cfg.KeepAlivePingPath
+	pingURL.RawQuery = ""
+
+	client := &http.Client{Timeout: defaultHTTPTimeout, Transport: transportFor(cfg)}
+	ticker := time.NewTicker(cfg.KeepAlivePingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, pingURL.String(), nil)
+		if err != nil {
+			cfg.log().Printf("Keep-alive ping to %s failed: %v", pingURL, err)
+			continue
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				cfg.log().Printf("Keep-alive ping to %s failed: %v", pingURL, err)
+			}
+			continue
+		}
+		drainAndClose(resp.Body, cfg.MaxResponseBytes)
+	}
+}
+
+// runIdleKeepAlive POSTs the --idle-keepalive-message to each of the sink's URLs whenever no message has been
+// sent to it for the idle interval, until the context is cancelled, for downstreams that close a stream they
+// consider idle. The marker is sent like a message, with the same headers and authentication, and carries the
+// X-Forwarder-Keepalive header.
+func runIdleKeepAlive(ctx context.Context, h *httpSink, cfg *Config) {
+	interval := cfg.IdleKeepAliveInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		idle := time.Since(time.Unix(0, h.lastSent.Load()))
+		if idle < interval {
+			timer.Reset(interval - idle)
+			continue
+		}
+		h.lastSent.Store(time.Now().UnixNano())
+		for _, target := range h.urls() {
+			marker := &PubSubMessage{
+				body:    []byte(cfg.IdleKeepAliveMessage),
+				headers: map[string]string{idleKeepAliveHeader: "true"},
+			}
+			if err := sendPOST(ctx, target, marker, cfg); err != nil && ctx.Err() == nil {
+				cfg.log().Printf("Idle keep-alive message to %s failed: %v", target, err)
+			}
+		}
+		timer.Reset(interval)
+	}
+}
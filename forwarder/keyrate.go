@@ -0,0 +1,85 @@
+package forwarder
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// keyLimiterMaxKeys bounds the number of ordering keys with a token bucket held in memory
+	keyLimiterMaxKeys = 10000
+	// keyLimiterIdle is how long a key's bucket is kept after its last message
+	keyLimiterIdle = 10 * time.Minute
+)
+
+// keyLimiterEntry is the token bucket of one ordering key and when it was last used
+type keyLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// keyLimiter limits the delivery rate of each ordering key with its own token bucket, so one hot key cannot
+// monopolize downstream capacity
+type keyLimiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	entries map[string]*keyLimiterEntry
+}
+
+// newKeyLimiter returns a limiter when --per-key-rate-limit is set, or nil otherwise
+func newKeyLimiter(cfg *Config) *keyLimiter {
+	if cfg.PerKeyRateLimit <= 0 {
+		return nil
+	}
+	return &keyLimiter{
+		limit:   rate.Limit(cfg.PerKeyRateLimit),
+		burst:   cfg.PerKeyBurst,
+		entries: make(map[string]*keyLimiterEntry),
+	}
+}
+
+// wait blocks until the ordering key may deliver another message, messages without a key are not limited
+func (k *keyLimiter) wait(ctx context.Context, key string) error {
+	if key == "" {
+		return nil
+	}
+	return k.limiterFor(key).Wait(ctx)
+}
+
+// limiterFor returns the key's token bucket, creating it and making room for it when needed
+func (k *keyLimiter) limiterFor(key string) *rate.Limiter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := k.entries[key]; ok {
+		entry.lastUsed = now
+		return entry.limiter
+	}
+	if len(k.entries) >= keyLimiterMaxKeys {
+		k.evict(now)
+	}
+	entry := &keyLimiterEntry{limiter: rate.NewLimiter(k.limit, k.burst), lastUsed: now}
+	k.entries[key] = entry
+	return entry.limiter
+}
+
+// evict removes idle buckets, and when none are idle an arbitrary bucket, to make room for a new key
+func (k *keyLimiter) evict(now time.Time) {
+	for key, entry := range k.entries {
+		if now.Sub(entry.lastUsed) > keyLimiterIdle {
+			delete(k.entries, key)
+		}
+	}
+	if len(k.entries) < keyLimiterMaxKeys {
+		return
+	}
+	for key := range k.entries {
+		delete(k.entries, key)
+		return
+	}
+}
@@ -0,0 +1,79 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// oldestUnackedAgeMetric is the Cloud Monitoring metric reporting the age of a subscription's oldest
+// unacknowledged message
+const oldestUnackedAgeMetric = "pubsub.googleapis.com/subscription/oldest_unacked_message_age"
+
+// lagLookback is how far back the latest sample is looked for, since Cloud Monitoring publishes Pub/Sub
+// metrics with a delay of a few minutes
+const lagLookback = 10 * time.Minute
+
+// runLagMonitor exports the age of the subscription's oldest unacknowledged message from Cloud Monitoring
+// every interval until the context is cancelled. Unlike the lag of received messages this includes the
+// backlog that has not been pulled yet.
+func runLagMonitor(ctx context.Context, cfg *Config, interval time.Duration) error {
+	client, err := monitoring.NewMetricClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create Cloud Monitoring client: %w", err)
+	}
+
+	go func() {
+		defer client.Close()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			age, err := oldestUnackedAge(ctx, client, cfg)
+			if err != nil && ctx.Err() == nil {
+				cfg.log().Printf("Error fetching oldest unacked message age: %v", err)
+			} else if err == nil {
+				oldestUnackedAgeSeconds.WithLabelValues(cfg.Subscription).Set(age.Seconds())
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// oldestUnackedAge returns the latest sample of the oldest unacked message age of the subscription
+func oldestUnackedAge(ctx context.Context, client *monitoring.MetricClient, cfg *Config) (time.Duration, error) {
+	now := time.Now()
+	it := client.ListTimeSeries(ctx, &monitoringpb.ListTimeSeriesRequest{
+		Name: "projects/" + cfg.Project,
+		Filter: fmt.Sprintf(`metric.type = %q AND resource.labels.subscription_id = %q`,
+			oldestUnackedAgeMetric, cfg.Subscription),
+		Interval: &monitoringpb.TimeInterval{
+			StartTime: timestamppb.New(now.Add(-lagLookback)),
+			EndTime:   timestamppb.New(now),
+		},
+		View: monitoringpb.ListTimeSeriesRequest_FULL,
+	})
+	series, err := it.Next()
+	if errors.Is(err, iterator.Done) {
+		return 0, fmt.Errorf("no %s samples in the last %s", oldestUnackedAgeMetric, lagLookback)
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Points are returned newest first
+	if len(series.Points) == 0 {
+		return 0, fmt.Errorf("no %s samples in the last %s", oldestUnackedAgeMetric, lagLookback)
+	}
+	return time.Duration(series.Points[0].GetValue().GetInt64Value()) * time.Second, nil
+}
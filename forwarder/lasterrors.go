@@ -0,0 +1,84 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// failureRecord is one delivery failure kept for the /lasterrors endpoint
+type failureRecord struct {
+	MessageID string `json:"messageId"`
+	// Subscription names the forwarder the failure belongs to on an admin server shared by several
+	Subscription string    `json:"subscription,omitempty"`
+	Time         time.Time `json:"time"`
+	Status       int       `json:"status,omitempty"`
+	Error        string    `json:"error"`
+}
+
+// recentErrors is a ring buffer of the most recent delivery failures, giving operators concrete examples of what
+// is failing without searching the logs
+type recentErrors struct {
+	mu      sync.Mutex
+	records []failureRecord
+	next    int
+	full    bool
+}
+
+// newRecentErrors returns a buffer of the last size failures, or nil when size is zero
+func newRecentErrors(size int) *recentErrors {
+	if size <= 0 {
+		return nil
+	}
+	return &recentErrors{records: make([]failureRecord, size)}
+}
+
+// record keeps the failure, overwriting the oldest one once the buffer is full
+func (r *recentErrors) record(messageID string, err error) {
+	if r == nil {
+		return
+	}
+	entry := failureRecord{MessageID: messageID, Time: time.Now().UTC(), Error: err.Error()}
+	var postErr *PostError
+	if errors.As(err, &postErr) {
+		entry.Status = postErr.StatusCode
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records[r.next] = entry
+	r.next = (r.next + 1) % len(r.records)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// snapshot returns the kept failures, newest first
+func (r *recentErrors) snapshot() []failureRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := r.next
+	if r.full {
+		count = len(r.records)
+	}
+	records := make([]failureRecord, 0, count)
+	for i := 1; i <= count; i++ {
+		records = append(records, r.records[(r.next-i+len(r.records))%len(r.records)])
+	}
+	return records
+}
+
+// handler serves the kept failures as a JSON array, newest first
+func (r *recentErrors) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		body, err := json.MarshalIndent(r.snapshot(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
@@ -0,0 +1,87 @@
+package forwarder
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// sizeBuckets are powers of two from 64 bytes to 8 MiB, covering typical Pub/Sub message sizes
+var sizeBuckets = prometheus.ExponentialBuckets(64, 2, 18)
+
+var (
+	messageSizeBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pubsubmsgrestforwarder_message_size_bytes",
+		Help:    "Size of the data of received Pub/Sub messages in bytes, by subscription.",
+		Buckets: sizeBuckets,
+	}, []string{"subscription"})
+	payloadSizeBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pubsubmsgrestforwarder_payload_size_bytes",
+		Help:    "Size of the serialized payload delivered to the sink in bytes, by subscription.",
+		Buckets: sizeBuckets,
+	}, []string{"subscription"})
+	handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pubsubmsgrestforwarder_handler_duration_seconds",
+		Help:    "Time from handler entry until the message is Acked or Nacked, by subscription and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"subscription", "outcome"})
+	identityTokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsubmsgrestforwarder_identity_token_refreshes_total",
+		Help: "Identity tokens minted for --auth-audience, including the first at startup, by outcome: success or failure.",
+	}, []string{"outcome"})
+	concurrencyLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pubsubmsgrestforwarder_concurrency_limit",
+		Help: "Current concurrent delivery limit chosen by --auto-concurrency.",
+	})
+	httpTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsubmsgrestforwarder_http_timeouts_total",
+		Help: "POSTs that timed out, by subscription and kind: hang when no response headers arrived within --downstream-hang-timeout, timeout otherwise.",
+	}, []string{"subscription", "kind"})
+	httpTLSErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsubmsgrestforwarder_http_tls_errors_total",
+		Help: "POSTs that failed the TLS handshake by subscription, such as for an expired or untrusted downstream certificate.",
+	}, []string{"subscription"})
+	expiredDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsubmsgrestforwarder_expired_dropped_total",
+		Help: "Messages Acked without forwarding because their --expiry-attribute time had passed, by subscription.",
+	}, []string{"subscription"})
+	undeliverableDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsubmsgrestforwarder_undeliverable_dropped_total",
+		Help: "Messages that fail on every redelivery, Acked and dropped for lack of a dead-letter topic, by subscription and reason.",
+	}, []string{"subscription", "reason"})
+	diskBufferDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsubmsgrestforwarder_disk_buffer_dropped_total",
+		Help: "Buffered messages dropped from the --disk-buffer-dir because their redelivery failed permanently, by subscription.",
+	}, []string{"subscription"})
+	workBufferDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pubsubmsgrestforwarder_work_buffer_depth",
+		Help: "Current number of received messages waiting in the --work-buffer-size buffer for a handler, by subscription.",
+	}, []string{"subscription"})
+	workBufferOverflows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsubmsgrestforwarder_work_buffer_overflow_total",
+		Help: "Messages Nacked on arrival because the work buffer was full with --work-buffer-overflow=nack, by subscription.",
+	}, []string{"subscription"})
+	messageLagSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pubsubmsgrestforwarder_message_lag_seconds",
+		Help: "Time between publishing and receiving the most recently received message, by subscription.",
+	}, []string{"subscription"})
+	oldestUnackedAgeSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pubsubmsgrestforwarder_oldest_unacked_message_age_seconds",
+		Help: "Age of the subscription's oldest unacknowledged message from Cloud Monitoring, by subscription.",
+	}, []string{"subscription"})
+	redeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsubmsgrestforwarder_redeliveries_total",
+		Help: "Messages received with a delivery attempt above 1, by subscription. Requires a dead letter policy.",
+	}, []string{"subscription"})
+	httpDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsubmsgrestforwarder_http_deliveries_total",
+		Help: "Successful HTTP deliveries by subscription and target, primary or failover.",
+	}, []string{"subscription", "target"})
+	http2Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsubmsgrestforwarder_http2_errors_total",
+		Help: "HTTP/2 protocol errors on downstream connections, by type such as recv_rststream_REFUSED_STREAM.",
+	}, []string{"type"})
+	balanceDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsubmsgrestforwarder_balance_deliveries_total",
+		Help: "HTTP deliveries to each --balance-url target, by subscription, target and outcome, success or failure.",
+	}, []string{"subscription", "target", "outcome"})
+)
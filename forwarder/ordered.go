@@ -0,0 +1,144 @@
+package forwarder
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// orderedWorkerQueueDepth is the number of messages that can wait for each ordered worker
+const orderedWorkerQueueDepth = 10
+
+// orderedJob is a message waiting for an ordered worker, done is closed once it has been handled
+type orderedJob struct {
+	ctx  context.Context
+	msg  *pubsub.Message
+	done chan struct{}
+}
+
+// orderedPool is a fixed pool of workers where each ordering key is hashed to a single worker, guaranteeing
+// per-key order with a bounded number of goroutines regardless of key cardinality
+type orderedPool struct {
+	queues []chan orderedJob
+	next   atomic.Uint64
+	wg     sync.WaitGroup
+}
+
+// newOrderedPool starts size workers that process messages with handle
+func newOrderedPool(size int, handle func(context.Context, *pubsub.Message)) *orderedPool {
+	p := &orderedPool{queues: make([]chan orderedJob, size)}
+	for i := range p.queues {
+		queue := make(chan orderedJob, orderedWorkerQueueDepth)
+		p.queues[i] = queue
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range queue {
+				handle(job.ctx, job.msg)
+				close(job.done)
+			}
+		}()
+	}
+	return p
+}
+
+// dispatch queues the message on the worker for its ordering key and waits until it has been handled.
+// Messages without an ordering key are spread round-robin across the workers.
+func (p *orderedPool) dispatch(ctx context.Context, msg *pubsub.Message) {
+	var index uint64
+	if msg.OrderingKey != "" {
+		hash := fnv.New64a()
+		hash.Write([]byte(msg.OrderingKey))
+		index = hash.Sum64() % uint64(len(p.queues))
+	} else {
+		index = p.next.Add(1) % uint64(len(p.queues))
+	}
+
+	job := orderedJob{ctx: ctx, msg: msg, done: make(chan struct{})}
+	select {
+	case p.queues[index] <- job:
+	case <-ctx.Done():
+		msg.Nack()
+		return
+	}
+	<-job.done
+}
+
+// stop closes the worker queues and waits for the workers to finish
+func (p *orderedPool) stop() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// Order retry policies for a message that finally fails on an ordered worker
+const (
+	orderRetrySkip = "skip"
+	orderRetryHalt = "halt"
+)
+
+// keyHalter stops an ordering key after a message on it fails with --order-retry-policy=halt, Nacking later
+// messages on the key so none is delivered ahead of the failed one. A Nacked message halts the key until it is
+// redelivered and delivered, a message that was given up on by dead-lettering, dropping or buffering halts it
+// until restart.
+type keyHalter struct {
+	mu sync.Mutex
+	// halted maps each halted ordering key to the ID of the message that halted it, empty when permanent
+	halted map[string]string
+	logger *log.Logger
+}
+
+// newKeyHalter returns a halter for the halt policy, or nil when failed messages are skipped
+func newKeyHalter(cfg *Config) *keyHalter {
+	if cfg.OrderRetryPolicy != orderRetryHalt {
+		return nil
+	}
+	return &keyHalter{halted: make(map[string]string), logger: cfg.log()}
+}
+
+// admit reports whether the message may be delivered, only the message that halted its key gets through
+func (h *keyHalter) admit(msg *pubsub.Message) bool {
+	if h == nil || msg.OrderingKey == "" {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	blocker, ok := h.halted[msg.OrderingKey]
+	return !ok || blocker == msg.ID
+}
+
+// fail halts the message's key, until the message is delivered when it will be redelivered and otherwise until
+// restart
+func (h *keyHalter) fail(msg *pubsub.Message, redelivered bool) {
+	if h == nil || msg.OrderingKey == "" {
+		return
+	}
+	blocker := ""
+	if redelivered {
+		blocker = msg.ID
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.halted[msg.OrderingKey]; !ok {
+		h.logger.Printf("Halting ordering key %q after message ID %s failed", msg.OrderingKey, msg.ID)
+	}
+	h.halted[msg.OrderingKey] = blocker
+}
+
+// delivered resumes the message's key when it had been halted by this message
+func (h *keyHalter) delivered(msg *pubsub.Message) {
+	if h == nil || msg.OrderingKey == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if blocker, ok := h.halted[msg.OrderingKey]; ok && blocker == msg.ID {
+		h.logger.Printf("Resuming ordering key %q after message ID %s was delivered", msg.OrderingKey, msg.ID)
+		delete(h.halted, msg.OrderingKey)
+	}
+}
@@ -0,0 +1,78 @@
+package forwarder
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// pauser lets consumption be paused and resumed at runtime, for downstream maintenance without restarting
+type pauser struct {
+	mu sync.Mutex
+	// resumed is closed when consumption resumes, nil while not paused
+	resumed chan struct{}
+	logger  *log.Logger
+}
+
+// pause stops messages from being delivered until resume is called
+func (p *pauser) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed == nil {
+		p.resumed = make(chan struct{})
+		p.logger.Println("Forwarding paused")
+	}
+}
+
+// resume releases any held messages and restores normal delivery
+func (p *pauser) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+		p.logger.Println("Forwarding resumed")
+	}
+}
+
+// waiting returns the channel closed on resume, or nil when not paused
+func (p *pauser) waiting() chan struct{} {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed
+}
+
+// handler returns an admin endpoint that applies action to POST requests
+func (p *pauser) handler(action func()) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		action()
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// hold blocks while consumption is paused, returning false when the message should be Nacked instead of
+// delivered because the pause mode is nack or the context was cancelled
+func (p *pauser) hold(ctx context.Context, mode string) bool {
+	resumed := p.waiting()
+	if resumed == nil {
+		return true
+	}
+	if mode == "nack" {
+		return false
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-waitDone(ctx):
+		return false
+	}
+}
@@ -0,0 +1,86 @@
+package forwarder
+
+import (
+	"context"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// pipelineJob is a message moving through the pipeline along with the channel its result is reported on
+type pipelineJob struct {
+	ctx     context.Context
+	msg     *pubsub.Message
+	payload *PubSubMessage
+	acked   chan bool
+}
+
+// pipeline splits handling into a pool of transform workers and a pool of send workers connected by a
+// bounded channel, so CPU heavy transforms and slow sends do not hold each other up while the channel
+// keeps backpressure between the stages
+type pipeline struct {
+	transforms chan pipelineJob
+	sends      chan pipelineJob
+	wg         sync.WaitGroup
+}
+
+// newPipeline starts the transform and send workers for the consumer
+func newPipeline(c *consumer, transformWorkers, sendWorkers int) *pipeline {
+	p := &pipeline{
+		transforms: make(chan pipelineJob),
+		sends:      make(chan pipelineJob, sendWorkers),
+	}
+
+	var transformWG sync.WaitGroup
+	for range transformWorkers {
+		transformWG.Add(1)
+		go func() {
+			defer transformWG.Done()
+			for job := range p.transforms {
+				payload, acked := c.prepare(job.ctx, job.msg)
+				if payload == nil {
+					job.acked <- acked
+					continue
+				}
+				job.payload = payload
+				p.sends <- job
+			}
+		}()
+	}
+	for range sendWorkers {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.sends {
+				job.acked <- c.send(job.ctx, job.msg, job.payload)
+			}
+		}()
+	}
+
+	// The send stage is closed once every transform worker has finished handing it work
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		transformWG.Wait()
+		close(p.sends)
+	}()
+	return p
+}
+
+// run passes the message through both stages and waits for it to be settled, returning whether it was Acked
+func (p *pipeline) run(ctx context.Context, msg *pubsub.Message) bool {
+	job := pipelineJob{ctx: ctx, msg: msg, acked: make(chan bool, 1)}
+	select {
+	case p.transforms <- job:
+	case <-ctx.Done():
+		msg.Nack()
+		return false
+	}
+	return <-job.acked
+}
+
+// stop waits for the workers to finish once Receive has returned
+func (p *pipeline) stop() {
+	close(p.transforms)
+	p.wg.Wait()
+}
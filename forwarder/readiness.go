@@ -0,0 +1,71 @@
+package forwarder
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// readiness tracks whether the forwarder is receiving messages and can still reach Pub/Sub, for the /readyz
+// endpoint of the admin server
+type readiness struct {
+	receiving atomic.Bool
+	failures  atomic.Int64
+	threshold int64
+	logger    *log.Logger
+}
+
+// ready reports whether Receive is running and the subscription has not failed the connectivity check
+// threshold times in a row
+func (r *readiness) ready() bool {
+	return r.receiving.Load() && (r.threshold <= 0 || r.failures.Load() < r.threshold)
+}
+
+// run checks the subscription is reachable with a lightweight metadata call every interval until the context
+// is cancelled, catching silent connectivity loss that Receive does not surface promptly. The result is
+// cached so /readyz never calls the Pub/Sub API itself.
+func (r *readiness) run(ctx context.Context, sub *pubsub.Subscription, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		exists, err := sub.Exists(checkCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && exists {
+			if r.failures.Swap(0) >= r.threshold {
+				r.logger.Println("Pub/Sub connectivity check recovered")
+			}
+			continue
+		}
+		if err == nil {
+			r.logger.Printf("Pub/Sub connectivity check failed: subscription %s no longer exists", sub.ID())
+		} else {
+			r.logger.Printf("Pub/Sub connectivity check failed: %v", err)
+		}
+		r.failures.Add(1)
+	}
+}
+
+// handler serves 200 when ready and 503 otherwise
+func (r *readiness) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !r.ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
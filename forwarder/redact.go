@@ -0,0 +1,77 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+)
+
+// ParseRedactPath parses a --redact-jsonpath expression, a sequence of .key and [index] steps such as
+// $.customer.cards[0].number
+func ParseRedactPath(expression string) ([]any, error) {
+	return parseJSONPath(expression)
+}
+
+// redactData replaces the value at each path of the JSON data with the mask. Data that is not JSON is returned
+// unchanged with a warning, as is data where no path matches so its formatting is kept.
+func redactData(data []byte, paths [][]any, mask string, messageID string, logger *log.Logger) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		logger.Printf("Warning: data of message ID %s is not JSON, forwarding it without redaction", messageID)
+		return data
+	}
+
+	redacted := false
+	for _, path := range paths {
+		if redactPath(document, path, mask) {
+			redacted = true
+		}
+	}
+	if !redacted {
+		return data
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(document); err != nil {
+		logger.Printf("Warning: failed to encode redacted data of message ID %s, forwarding it without redaction: %v", messageID, err)
+		return data
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// redactPath walks to the parent of the last step and replaces the value there, returning whether it existed
+func redactPath(value any, path []any, mask string) bool {
+	for i, step := range path {
+		last := i == len(path)-1
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]any)
+			if !ok {
+				return false
+			}
+			if _, ok := object[step]; !ok {
+				return false
+			}
+			if last {
+				object[step] = mask
+				return true
+			}
+			value = object[step]
+		case int:
+			array, ok := value.([]any)
+			if !ok || step >= len(array) {
+				return false
+			}
+			if last {
+				array[step] = mask
+				return true
+			}
+			value = array[step]
+		}
+	}
+	return false
+}
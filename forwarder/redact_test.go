@@ -0,0 +1,53 @@
+package forwarder
+
+import (
+	"log"
+	"testing"
+)
+
+func TestRedactDataKeepsLargeIntegers(t *testing.T) {
+	path, err := ParseRedactPath("$.card")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Re-encoding after redaction must not round the ID through float64
+	got := redactData([]byte(`{"id":9007199254740993,"card":"4111"}`), [][]any{path}, "***", "1", log.Default())
+	if want := `{"card":"***","id":9007199254740993}`; string(got) != want {
+		t.Errorf("redactData() = %s, want %s", got, want)
+	}
+}
+
+func TestRedactDataNestedPaths(t *testing.T) {
+	data := []byte(`{"customer":{"name":"Ada","cards":[{"number":"4111","expiry":"12/30"},{"number":"5500"}]},"total":12}`)
+	tests := []struct {
+		name  string
+		paths []string
+		want  string
+	}{
+		{"nested object", []string{"$.customer.name"}, `{"customer":{"cards":[{"expiry":"12/30","number":"4111"},{"number":"5500"}],"name":"***"},"total":12}`},
+		{"array element field", []string{"$.customer.cards[1].number"}, `{"customer":{"cards":[{"expiry":"12/30","number":"4111"},{"number":"***"}],"name":"Ada"},"total":12}`},
+		{"whole subtree", []string{"$.customer.cards[0]"}, `{"customer":{"cards":["***",{"number":"5500"}],"name":"Ada"},"total":12}`},
+		{"several paths", []string{"$.customer.name", "$.customer.cards[0].number"}, `{"customer":{"cards":[{"expiry":"12/30","number":"***"},{"number":"5500"}],"name":"***"},"total":12}`},
+		// Without a match the data is forwarded exactly as published
+		{"no match", []string{"$.customer.cards[5].number", "$.missing.field"}, string(data)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths [][]any
+			for _, expression := range tt.paths {
+				path, err := ParseRedactPath(expression)
+				if err != nil {
+					t.Fatal(err)
+				}
+				paths = append(paths, path)
+			}
+			if got := redactData(data, paths, "***", "1", log.Default()); string(got) != tt.want {
+				t.Errorf("redactData() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := redactData([]byte("not json"), [][]any{{"customer"}}, "***", "1", log.Default()); string(got) != "not json" {
+		t.Errorf("redactData() = %s, want non-JSON data unchanged", got)
+	}
+}
@@ -0,0 +1,118 @@
+package forwarder
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// reorderItem is a message waiting in the reorder buffer
+type reorderItem struct {
+	ctx     context.Context
+	msg     *pubsub.Message
+	arrived time.Time
+	done    chan struct{}
+}
+
+// reorderHeap orders buffered messages by publish time
+type reorderHeap []*reorderItem
+
+func (h reorderHeap) Len() int           { return len(h) }
+func (h reorderHeap) Less(i, j int) bool { return h[i].msg.PublishTime.Before(h[j].msg.PublishTime) }
+func (h reorderHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *reorderHeap) Push(x any)        { *h = append(*h, x.(*reorderItem)) }
+func (h *reorderHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// reorderBuffer holds messages for up to a window and forwards them one at a time in publish time order,
+// smoothing out-of-order arrival from concurrent pulls. The earliest published message is forwarded once it
+// has waited the full window, or straight away when the buffer is full.
+type reorderBuffer struct {
+	mu     sync.Mutex
+	items  reorderHeap
+	window time.Duration
+	size   int
+	handle func(context.Context, *pubsub.Message)
+	wake   chan struct{}
+	done   chan struct{}
+}
+
+// newReorderBuffer starts forwarding buffered messages to handle until ctx is cancelled, after which the
+// remaining messages are passed to handle immediately so they are Nacked
+func newReorderBuffer(ctx context.Context, window time.Duration, size int, handle func(context.Context, *pubsub.Message)) *reorderBuffer {
+	r := &reorderBuffer{
+		window: window,
+		size:   size,
+		handle: handle,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go r.run(ctx)
+	return r
+}
+
+// add buffers the message and waits until it has been forwarded and settled
+func (r *reorderBuffer) add(ctx context.Context, msg *pubsub.Message) {
+	item := &reorderItem{ctx: ctx, msg: msg, arrived: time.Now(), done: make(chan struct{})}
+	r.mu.Lock()
+	heap.Push(&r.items, item)
+	r.mu.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	<-item.done
+}
+
+// next pops the message due to be forwarded, or returns how long until the earliest one is due
+func (r *reorderBuffer) next(flush bool) (*reorderItem, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.items) == 0 {
+		return nil, r.window
+	}
+	wait := time.Until(r.items[0].arrived.Add(r.window))
+	if flush || wait <= 0 || len(r.items) >= r.size {
+		return heap.Pop(&r.items).(*reorderItem), 0
+	}
+	return nil, wait
+}
+
+// run forwards due messages in publish time order
+func (r *reorderBuffer) run(ctx context.Context) {
+	timer := time.NewTimer(r.window)
+	defer timer.Stop()
+	cancelled := ctx.Done()
+	for {
+		item, wait := r.next(ctx.Err() != nil)
+		if item != nil {
+			r.handle(item.ctx, item.msg)
+			close(item.done)
+			continue
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-r.done:
+			return
+		case <-r.wake:
+		case <-timer.C:
+		case <-cancelled:
+			// Stop selecting on the closed channel, the remaining messages are flushed by next
+			cancelled = nil
+		}
+	}
+}
+
+// stop ends forwarding once Receive has returned and every buffered message has been settled
+func (r *reorderBuffer) stop() {
+	close(r.done)
+}
@@ -0,0 +1,93 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Replay delivers the messages of a --capture-file to the configured sinks without connecting to Pub/Sub, so
+// a downstream handler can be exercised repeatably with the same messages. Each message goes through the same
+// filtering and transformation as when it was received, except for cfg.MaxMessageAge and cfg.NackDelay, limited
+// to cfg.ReplayMessageIDs when set. Messages
+// are replayed one at a time in the order they were captured, and a failed delivery is logged and counted
+// rather than retried by redelivery.
+func Replay(ctx context.Context, cfg *Config) error {
+	var schema *jsonschema.Schema
+	if cfg.SchemaFile != "" {
+		var err error
+		if schema, err = loadSchema(cfg.SchemaFile); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+	}
+	transform, err := loadWASMTransform(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	defer transform.Close(context.Background())
+	decoder, err := newDecoder(cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	enricher, err := newEnricher(cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if cfg.identityTokens, err = newIdentityTokenSource(ctx, cfg); err != nil {
+		return fmt.Errorf("%w: %w", ErrDownstreamAuth, err)
+	}
+
+	sinks, err := openSinks(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	defer sinks.Close()
+	if cfg.LoginURL != "" {
+		if err := sessionFor(cfg).login(ctx); err != nil {
+			return fmt.Errorf("%w: %w", ErrDownstreamUnreachable, err)
+		}
+	}
+
+	// Without Pub/Sub there is no dead-letter topic, so messages it would receive are only logged
+	c := newConsumer(cfg, sinks.Send, newHeartbeat(cfg.log()), schema, nil)
+	c.pause = &pauser{logger: cfg.log()}
+	c.replay = true
+	c.transform = transform
+	c.decoder = decoder
+	c.enricher = enricher
+
+	var delivered, failed, skipped int
+	err = readCapture(cfg.ReplayFile, func(msg *pubsub.Message) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if len(cfg.ReplayMessageIDs) > 0 && !slices.Contains(cfg.ReplayMessageIDs, msg.ID) {
+			return nil
+		}
+		transformed, _ := c.prepare(ctx, msg)
+		if transformed == nil {
+			cfg.log().Printf("Skipped replaying message ID %s", msg.ID)
+			skipped++
+			return nil
+		}
+		if err := c.deliver(ctx, transformed); err != nil {
+			cfg.log().Printf("Failed to replay message ID %s: %v", msg.ID, err)
+			failed++
+			return nil
+		}
+		cfg.log().Printf("Replayed message ID %s", msg.ID)
+		delivered++
+		return nil
+	})
+	cfg.log().Printf("Replay finished: %d delivered, %d failed, %d skipped", delivered, failed, skipped)
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replayed messages failed", failed, delivered+failed)
+	}
+	return nil
+}
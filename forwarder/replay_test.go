@@ -0,0 +1,50 @@
+package forwarder
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+func TestReplayIgnoresMessageAgeAndNackDelay(t *testing.T) {
+	var delivered atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered.Add(1)
+	}))
+	defer server.Close()
+
+	// A message captured a day ago is well past --max-message-age by the time it is replayed
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	capture, err := openCaptureLog(path, log.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.record(&pubsub.Message{ID: "1", Data: []byte(`{"id":1}`), PublishTime: time.Now().Add(-24 * time.Hour)})
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{URL: server.URL, ReplayFile: path, SampleRate: 1, MaxMessageAge: time.Hour, NackDelay: time.Hour}
+	if err := Replay(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := delivered.Load(); got != 1 {
+		t.Errorf("delivered %d messages, want the old captured message", got)
+	}
+
+	// Nothing redelivers a failed replayed message, so holding it back would only stall the replay
+	c := newConsumer(cfg, nil, newHeartbeat(log.Default()), nil, nil)
+	c.replay = true
+	start := time.Now()
+	c.nack(context.Background(), &pubsub.Message{})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("nack took %s, want no --nack-delay in replay", elapsed)
+	}
+}
@@ -0,0 +1,21 @@
+package forwarder
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+)
+
+// sampled reports whether a message is forwarded under the configured sample rate. Deterministic sampling
+// hashes the message ID so a redelivered message gets the same decision.
+func sampled(messageID string, cfg *Config) bool {
+	if cfg.SampleRate >= 1 {
+		return true
+	}
+	if cfg.SampleDeterministic {
+		h := fnv.New64a()
+		h.Write([]byte(messageID))
+		return float64(h.Sum64())/math.MaxUint64 < cfg.SampleRate
+	}
+	return rand.Float64() < cfg.SampleRate
+}
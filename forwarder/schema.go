@@ -0,0 +1,30 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// loadSchema compiles the JSON Schema file used to validate message data
+func loadSchema(path string) (*jsonschema.Schema, error) {
+	schema, err := jsonschema.NewCompiler().Compile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JSON schema %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+// validateData validates JSON message data against the schema, data that is not JSON is not validated
+func validateData(schema *jsonschema.Schema, data []byte) error {
+	if !json.Valid(data) {
+		return nil
+	}
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse message data: %w", err)
+	}
+	return schema.Validate(instance)
+}
@@ -0,0 +1,100 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// session keeps the cookies issued by a session based downstream and logs in again when the session expires.
+// A single session is shared by every concurrent delivery.
+type session struct {
+	jar *cookiejar.Jar
+	cfg *Config
+
+	mu       sync.Mutex
+	loggedIn time.Time
+}
+
+// sessions holds the session for each config so cookies are shared across POSTs
+var sessions sync.Map
+
+// sessionFor returns the shared session for the config, or nil when cookies are not kept
+func sessionFor(cfg *Config) *session {
+	if !cfg.CookieJar && cfg.LoginURL == "" {
+		return nil
+	}
+	if s, ok := sessions.Load(cfg); ok {
+		return s.(*session)
+	}
+	// New only fails for invalid options and nil options are always valid
+	jar, _ := cookiejar.New(nil)
+	actual, _ := sessions.LoadOrStore(cfg, &session{jar: jar, cfg: cfg})
+	return actual.(*session)
+}
+
+// cookies returns the jar for the HTTP client, or nil when cookies are not kept
+func (s *session) cookies() http.CookieJar {
+	if s == nil {
+		return nil
+	}
+	return s.jar
+}
+
+// login posts the username and password as a form to the login URL, storing the session cookies it sets
+func (s *session) login(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loginLocked(ctx)
+}
+
+// relogin logs in again after a 401, unless another delivery already did since the failed request started
+func (s *session) relogin(ctx context.Context, since time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loggedIn.After(since) {
+		return nil
+	}
+	s.cfg.log().Printf("Session expired, logging in again at %s", s.cfg.LoginURL)
+	return s.loginLocked(ctx)
+}
+
+// loginLocked performs the login, the caller must hold the mutex
+func (s *session) loginLocked(ctx context.Context) error {
+	password, err := os.ReadFile(s.cfg.LoginPasswordFile)
+	if err != nil {
+		return fmt.Errorf("failed to read login password file: %w", err)
+	}
+	form := url.Values{
+		"username": {s.cfg.LoginUsername},
+		"password": {strings.TrimSpace(string(password))},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.LoginURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create login request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Transport: transportFor(s.cfg), Jar: s.jar}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("login request failed: %w", err)
+	}
+	defer drainAndClose(resp.Body, s.cfg.MaxResponseBytes)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("login failed. HTTP Status: %s", resp.Status)
+	}
+
+	s.loggedIn = time.Now()
+	s.cfg.log().Printf("Logged in at %s", s.cfg.LoginURL)
+	return nil
+}
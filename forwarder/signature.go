@@ -0,0 +1,49 @@
+package forwarder
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Signature schemes for the HMAC of the request body
+const (
+	signatureGeneric = "generic"
+	signatureStripe  = "stripe"
+	signatureGitHub  = "github"
+)
+
+// defaultSignatureHeaders are the header names each scheme's receivers look for
+var defaultSignatureHeaders = map[string]string{
+	signatureGeneric: "X-Signature",
+	signatureStripe:  "Stripe-Signature",
+	signatureGitHub:  "X-Hub-Signature-256",
+}
+
+// signRequest sets the HMAC-SHA256 signature of the body in the format of the configured scheme. Stripe style
+// signs the timestamp and body joined by a dot as t=<timestamp>,v1=<hex>, GitHub style sends sha256=<hex> and
+// generic sends the bare hex signature.
+func signRequest(header http.Header, body []byte, cfg *Config, now time.Time) {
+	name := cfg.SignatureHeader
+	if name == "" {
+		name = defaultSignatureHeaders[cfg.SignatureScheme]
+	}
+
+	mac := hmac.New(sha256.New, []byte(cfg.SigningSecret))
+	switch cfg.SignatureScheme {
+	case signatureStripe:
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		header.Set(name, "t="+timestamp+",v1="+hex.EncodeToString(mac.Sum(nil)))
+	case signatureGitHub:
+		mac.Write(body)
+		header.Set(name, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	default:
+		mac.Write(body)
+		header.Set(name, hex.EncodeToString(mac.Sum(nil)))
+	}
+}
@@ -0,0 +1,44 @@
+package forwarder
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignRequest(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte("Hello, World!")
+	secret := "It's a Secret to Everybody"
+
+	// The example of GitHub's webhook documentation
+	github := "757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	// Stripe signs the timestamp and the body joined by a dot
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("1700000000.Hello, World!"))
+	stripe := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		scheme string
+		header string
+		name   string
+		want   string
+	}{
+		{signatureGeneric, "", "X-Signature", github},
+		{signatureStripe, "", "Stripe-Signature", "t=1700000000,v1=" + stripe},
+		{signatureGitHub, "", "X-Hub-Signature-256", "sha256=" + github},
+		{signatureGitHub, "X-Custom-Signature", "X-Custom-Signature", "sha256=" + github},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme+" "+tt.name, func(t *testing.T) {
+			header := http.Header{}
+			signRequest(header, body, &Config{SigningSecret: secret, SignatureScheme: tt.scheme, SignatureHeader: tt.header}, now)
+			if got := header.Get(tt.name); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,473 @@
+package forwarder
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sink delivers a transformed message to a single destination
+type Sink interface {
+	Send(ctx context.Context, payload *PubSubMessage) error
+}
+
+// SinkSpec configures one destination, parsed from a --sink value of the form type[:key=value,...]
+type SinkSpec struct {
+	// Type is the kind of destination: http, kafka, gcs, file, exec, eventhubs or grpc
+	Type string
+	// Options holds the type specific settings such as url for http or bucket for gcs
+	Options map[string]string
+	// Optional sinks have their failures logged without affecting whether the message is Acked
+	Optional bool
+	// Timeout bounds each attempt to send to the sink, 0 leaves the sink's own timeout
+	Timeout time.Duration
+	// Retries is how many more times a failed send is attempted before the sink fails the message
+	Retries int
+	// Backoff is the delay before the first retry, doubling for each further retry
+	Backoff time.Duration
+
+	// retriesSet and backoffSet record whether Retries and Backoff were given as options rather than
+	// defaulting to --max-retries and --retry-base-delay
+	retriesSet bool
+	backoffSet bool
+}
+
+// String formats the spec in the same form it is parsed from
+func (s SinkSpec) String() string {
+	var options []string
+	for key, value := range s.Options {
+		options = append(options, key+"="+value)
+	}
+	if s.Optional {
+		options = append(options, "optional=true")
+	}
+	if s.Timeout > 0 {
+		options = append(options, "timeout="+s.Timeout.String())
+	}
+	if s.Retries > 0 {
+		options = append(options, "retries="+strconv.Itoa(s.Retries), "backoff="+s.Backoff.String())
+	}
+	if len(options) == 0 {
+		return s.Type
+	}
+	return s.Type + ":" + strings.Join(options, ",")
+}
+
+// ParseSinkSpec parses a sink spec of the form type[:key=value,...]. The optional=true option marks a sink
+// whose failures do not cause the message to be Nacked, and the timeout, retries and backoff options set the
+// sink's own retry profile.
+func ParseSinkSpec(value string) (SinkSpec, error) {
+	sinkType, rest, _ := strings.Cut(value, ":")
+	spec := SinkSpec{Type: sinkType, Options: map[string]string{}, Backoff: defaultSinkBackoff}
+	if rest != "" {
+		for _, option := range strings.Split(rest, ",") {
+			key, val, ok := strings.Cut(option, "=")
+			if !ok || key == "" {
+				return SinkSpec{}, fmt.Errorf("invalid sink option %q in %q: must be key=value", option, value)
+			}
+			var err error
+			switch key {
+			case "optional":
+				spec.Optional = val == "true"
+			case "timeout":
+				spec.Timeout, err = time.ParseDuration(val)
+				if err == nil && spec.Timeout <= 0 {
+					err = errors.New("must be positive")
+				}
+			case "retries":
+				spec.Retries, err = strconv.Atoi(val)
+				if err == nil && spec.Retries < 0 {
+					err = errors.New("must not be negative")
+				}
+				spec.retriesSet = true
+			case "backoff":
+				spec.Backoff, err = time.ParseDuration(val)
+				if err == nil && spec.Backoff <= 0 {
+					err = errors.New("must be positive")
+				}
+				spec.backoffSet = true
+			default:
+				spec.Options[key] = val
+			}
+			if err != nil {
+				return SinkSpec{}, fmt.Errorf("invalid sink option %q in %q: %w", option, value, err)
+			}
+		}
+	}
+
+	switch spec.Type {
+	case "http":
+		if (spec.Options["cert"] == "") != (spec.Options["key"] == "") {
+			return SinkSpec{}, fmt.Errorf("invalid sink %q: http cert and key options must be set together", value)
+		}
+	case "kafka":
+	case "gcs":
+		if spec.Options["bucket"] == "" {
+			return SinkSpec{}, fmt.Errorf("invalid sink %q: gcs requires a bucket option", value)
+		}
+	case "file", "exec", "eventhubs":
+	case "grpc":
+		if spec.Options["target"] == "" || spec.Options["method"] == "" {
+			return SinkSpec{}, fmt.Errorf("invalid sink %q: grpc requires target and method options", value)
+		}
+	default:
+		return SinkSpec{}, fmt.Errorf("invalid sink %q: type must be http, kafka, gcs, file, exec, eventhubs or grpc", value)
+	}
+	return spec, nil
+}
+
+// httpSink POSTs messages to a URL, or to one of the --balance-url targets when balance is set
+type httpSink struct {
+	url     string
+	cfg     *Config
+	balance *balancer
+	// lastSent is when a message was last sent in Unix nanoseconds, for --idle-keepalive-interval
+	lastSent atomic.Int64
+}
+
+// httpSinkConfig returns the config for an HTTP sink, a copy with its own TLS settings when the sink has any
+// of the ca, cert, key, server-name or insecure-skip-verify options so it gets its own transport. Files are
+// loaded here so an invalid setting fails at startup.
+func httpSinkConfig(cfg *Config, spec SinkSpec) (*Config, error) {
+	ca, cert, key := spec.Options["ca"], spec.Options["cert"], spec.Options["key"]
+	serverName, skipVerify := spec.Options["server-name"], spec.Options["insecure-skip-verify"] == "true"
+	if ca == "" && cert == "" && key == "" && serverName == "" && !skipVerify {
+		return cfg, nil
+	}
+
+	tlsConfig := &tls.Config{ServerName: cfg.TLSServerName, MinVersion: cfg.MinTLSVersion, InsecureSkipVerify: skipVerify}
+	if serverName != "" {
+		tlsConfig.ServerName = serverName
+	}
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http sink CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid http sink CA file %s: no PEM certificates found", ca)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http sink client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	if skipVerify {
+		cfg.log().Printf("Warning: TLS certificate verification is disabled for the http sink %s", spec)
+	}
+
+	sinkCfg := *cfg
+	sinkCfg.tlsConfig = tlsConfig
+	return &sinkCfg, nil
+}
+
+// urls returns every URL the sink delivers to apart from the failover URL
+func (h *httpSink) urls() []string {
+	if h.balance == nil {
+		return []string{h.url}
+	}
+	urls := make([]string, len(h.balance.targets))
+	for i, target := range h.balance.targets {
+		urls[i] = target.URL
+	}
+	return urls
+}
+
+func (h *httpSink) Send(ctx context.Context, payload *PubSubMessage) error {
+	h.lastSent.Store(time.Now().UnixNano())
+	url := h.url
+	if payload.url != "" {
+		url = payload.url
+	} else if h.balance != nil {
+		url = h.balance.next()
+		err := postWithFailover(ctx, url, payload, h.cfg)
+		outcome := "success"
+		if err != nil {
+			outcome = "failure"
+		}
+		balanceDeliveries.WithLabelValues(h.cfg.Subscription, redactURL(url), outcome).Inc()
+		return err
+	}
+	return postWithFailover(ctx, url, payload, h.cfg)
+}
+
+// permanentError marks a sink failure that retrying cannot fix, so the message is dead-lettered instead
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// permanent marks err as a failure that retrying cannot fix
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// isPermanent reports whether a sink failed the message permanently
+func isPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// isClientError reports whether the downstream rejected the request with a 4xx status other than 429, which
+// the same request would receive again
+func isClientError(err error) bool {
+	var postErr *PostError
+	return errors.As(err, &postErr) && postErr.StatusCode >= 400 && postErr.StatusCode < 500 &&
+		postErr.StatusCode != http.StatusTooManyRequests
+}
+
+// defaultSinkBackoff is the delay before the first retry of a sink without a backoff option
+const defaultSinkBackoff = 500 * time.Millisecond
+
+// retryJitterDivisor bounds the random delay added to each backoff, up to a fifth of it, so messages failing
+// together do not retry in lockstep
+const retryJitterDivisor = 5
+
+// configuredSink is an opened sink along with the spec it was created from
+type configuredSink struct {
+	spec  SinkSpec
+	sink  Sink
+	audit *auditLog
+	// retryMaxDelay caps the wait a Retry-After header can ask for between retries
+	retryMaxDelay time.Duration
+	// retryTotalTimeout bounds all attempts and retries of a message together, 0 for no bound
+	retryTotalTimeout time.Duration
+	logger            *log.Logger
+}
+
+// multiSink delivers each message to every configured sink, succeeding only when all required sinks succeed
+type multiSink struct {
+	sinks  []configuredSink
+	audit  *auditLog
+	logger *log.Logger
+}
+
+// openSinks creates the sinks configured in cfg.Sinks, defaulting to a single HTTP sink for cfg.URL
+func openSinks(ctx context.Context, cfg *Config) (*multiSink, error) {
+	specs := cfg.Sinks
+	if len(specs) == 0 {
+		specs = []SinkSpec{{Type: "http"}}
+	}
+
+	audit, err := openAuditLog(cfg.AuditLogFile)
+	if err != nil {
+		return nil, err
+	}
+	m := &multiSink{audit: audit, logger: cfg.log()}
+	for _, spec := range specs {
+		// Sinks without a retry profile of their own follow --max-retries and --retry-base-delay
+		if !spec.retriesSet {
+			spec.Retries = cfg.MaxRetries
+		}
+		if !spec.backoffSet && cfg.RetryBaseDelay > 0 {
+			spec.Backoff = cfg.RetryBaseDelay
+		}
+		if spec.Backoff <= 0 {
+			spec.Backoff = defaultSinkBackoff
+		}
+
+		var sink Sink
+		switch spec.Type {
+		case "http":
+			url := cfg.URL
+			if spec.Options["url"] != "" {
+				url = spec.Options["url"]
+			}
+			sinkCfg, err := httpSinkConfig(cfg, spec)
+			if err != nil {
+				m.Close()
+				return nil, err
+			}
+			h := &httpSink{url: url, cfg: sinkCfg}
+			// Balancing replaces the default URL, while a sink with its own url option keeps it
+			if spec.Options["url"] == "" {
+				h.balance = newBalancer(cfg)
+			}
+			sink = h
+		case "kafka":
+			sink = newKafkaSink(cfg, spec.Options["topic"])
+		case "gcs":
+			gcs, err := newGCSSink(ctx, cfg, spec.Options["bucket"], spec.Options["prefix"])
+			if err != nil {
+				m.Close()
+				return nil, err
+			}
+			sink = gcs
+		case "file":
+			path := cfg.OutputFile
+			if spec.Options["path"] != "" {
+				path = spec.Options["path"]
+			}
+			file, err := newFileSink(cfg, path)
+			if err != nil {
+				m.Close()
+				return nil, err
+			}
+			sink = file
+		case "exec":
+			sink = newExecSink(cfg, cfg.ExecCommand)
+		case "eventhubs":
+			hub := cfg.EventHubsName
+			if spec.Options["hub"] != "" {
+				hub = spec.Options["hub"]
+			}
+			eventHubs, err := newEventHubsSink(cfg, cfg.EventHubsConnectionString, hub)
+			if err != nil {
+				m.Close()
+				return nil, err
+			}
+			sink = eventHubs
+		case "grpc":
+			grpcSink, err := newGRPCSink(cfg, spec.Options["target"], spec.Options["method"], spec.Options["plaintext"] == "true")
+			if err != nil {
+				m.Close()
+				return nil, err
+			}
+			sink = grpcSink
+		default:
+			m.Close()
+			return nil, fmt.Errorf("unsupported sink type %q", spec.Type)
+		}
+		m.sinks = append(m.sinks, configuredSink{spec: spec, sink: sink, audit: m.audit, retryMaxDelay: cfg.RetryMaxDelay, retryTotalTimeout: cfg.RetryTotalTimeout, logger: m.logger})
+	}
+	return m, nil
+}
+
+// Send delivers the message to all sinks concurrently. Failures of optional sinks are logged, failures of
+// required sinks are returned so the message is Nacked and redelivered to every sink.
+func (m *multiSink) Send(ctx context.Context, payload *PubSubMessage) error {
+	if len(m.sinks) == 1 {
+		return m.sinks[0].check(m.sinks[0].send(ctx, payload), payload)
+	}
+
+	errs := make([]error, len(m.sinks))
+	var wg sync.WaitGroup
+	for i, s := range m.sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.check(s.send(ctx, payload), payload); err != nil {
+				errs[i] = fmt.Errorf("%s sink: %w", s.spec.Type, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// send delivers the message to the sink, bounding each attempt by the sink's timeout and retrying failures
+// with exponential backoff and jitter. A 4xx response other than 429 fails at once, since the same request
+// would be rejected again. With a total retry timeout, attempts are cut off when it ends and no retry is
+// started that could not begin before then, even if retries remain.
+func (s configuredSink) send(ctx context.Context, payload *PubSubMessage) error {
+	if s.retryTotalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.retryTotalTimeout)
+		defer cancel()
+	}
+	backoff := s.spec.Backoff
+	for attempt := 0; ; attempt++ {
+		err := s.attempt(ctx, payload)
+		if err == nil || attempt >= s.spec.Retries || isPermanent(err) {
+			return err
+		}
+		if isClientError(err) {
+			return err
+		}
+
+		// A downstream asking to be retried later is honored instead of the backoff, within the configured cap
+		delay := backoff + rand.N(backoff/retryJitterDivisor+1)
+		var postErr *PostError
+		if errors.As(err, &postErr) && postErr.RetryAfter > 0 {
+			delay = min(postErr.RetryAfter, s.retryMaxDelay)
+		}
+		if deadline, ok := ctx.Deadline(); ok && s.retryTotalTimeout > 0 && time.Until(deadline) <= delay {
+			s.logger.Printf("%s sink failed for message ID %s, giving up as --retry-total-timeout ends before the next retry: %v",
+				s.spec.Type, payload.Message.MessageID, err)
+			return err
+		}
+		s.logger.Printf("%s sink failed for message ID %s, retrying in %s: %v", s.spec.Type, payload.Message.MessageID, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends the message once, within the sink's timeout when one is configured, and records the attempt
+// in the audit log. A record that cannot be written fails the attempt so no unaudited message is Acked.
+func (s configuredSink) attempt(ctx context.Context, payload *PubSubMessage) error {
+	if s.spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.spec.Timeout)
+		defer cancel()
+		ctx = context.WithValue(ctx, sinkTimeoutKey{}, s.spec.Timeout)
+	}
+	var called string
+	err := s.sink.Send(context.WithValue(ctx, calledURLKey{}, &called), payload)
+	if auditErr := s.audit.record(payload, s.target(called), err); auditErr != nil {
+		return errors.Join(err, auditErr)
+	}
+	return err
+}
+
+// target describes the destination in audit records: the URL the attempt was sent to, the configured URL for
+// an HTTP sink that failed before sending, and the spec otherwise
+func (s configuredSink) target(called string) string {
+	if called != "" {
+		return called
+	}
+	if h, ok := s.sink.(*httpSink); ok {
+		return h.url
+	}
+	return s.spec.String()
+}
+
+// check returns the error of a required sink and only logs the error of an optional sink
+func (s configuredSink) check(err error, payload *PubSubMessage) error {
+	if err == nil {
+		return nil
+	}
+	if s.spec.Optional {
+		s.logger.Printf("Optional sink %s failed for message ID %s: %v", s.spec.Type, payload.Message.MessageID, err)
+		return nil
+	}
+	return err
+}
+
+// Close closes every sink that holds resources such as producers or clients
+func (m *multiSink) Close() {
+	for _, s := range m.sinks {
+		if closer, ok := s.sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				m.logger.Printf("Error closing %s sink: %v", s.spec.Type, err)
+			}
+		}
+	}
+	if err := m.audit.Close(); err != nil {
+		m.logger.Printf("Error closing audit log: %v", err)
+	}
+}
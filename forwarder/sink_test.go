@@ -0,0 +1,273 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSinkRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		attempts int32
+	}{
+		{"succeeds after transient failures", []int{503, 503, 200}, false, 3},
+		{"fails fast on a client error", []int{400}, true, 1},
+		{"gives up after max retries", []int{503}, true, 4},
+		{"retries rate limiting", []int{429, 200}, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(attempts.Add(1)) - 1
+				w.WriteHeader(tt.statuses[min(n, len(tt.statuses)-1)])
+			}))
+			defer server.Close()
+
+			cfg := &Config{URL: server.URL, MaxRetries: 3, RetryBaseDelay: time.Millisecond, RetryMaxDelay: time.Second}
+			sinks, err := openSinks(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sinks.Close()
+
+			payload := &PubSubMessage{}
+			payload.Message.MessageID = "1"
+			err = sinks.Send(context.Background(), payload)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Send() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := attempts.Load(); got != tt.attempts {
+				t.Errorf("made %d attempts, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestSinkRetryStopsOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := &Config{URL: server.URL, MaxRetries: 3, RetryBaseDelay: time.Hour, RetryMaxDelay: time.Second}
+	sinks, err := openSinks(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sinks.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := sinks.Send(ctx, &PubSubMessage{}); err == nil {
+		t.Fatal("Send() succeeded, want the 503 error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Send() took %s, want the backoff to end with the context", elapsed)
+	}
+}
+
+func TestParseSinkSpecRetryDefaults(t *testing.T) {
+	spec, err := ParseSinkSpec("http:retries=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinks, err := openSinks(context.Background(), &Config{Sinks: []SinkSpec{spec}, MaxRetries: 5, RetryBaseDelay: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sinks.Close()
+	// The sink's own option wins over --max-retries, while the unset backoff follows --retry-base-delay
+	if got := sinks.sinks[0].spec; got.Retries != 1 || got.Backoff != time.Second {
+		t.Errorf("retries = %d, backoff = %s, want 1 and 1s", got.Retries, got.Backoff)
+	}
+}
+
+// sinkFunc adapts a function to the Sink interface
+type sinkFunc func(ctx context.Context, payload *PubSubMessage) error
+
+func (f sinkFunc) Send(ctx context.Context, payload *PubSubMessage) error { return f(ctx, payload) }
+
+func TestSinkTimeoutSetsRequestTimeout(t *testing.T) {
+	var got time.Duration
+	s := configuredSink{
+		spec: SinkSpec{Type: "http", Timeout: 30 * time.Second},
+		sink: sinkFunc(func(ctx context.Context, payload *PubSubMessage) error {
+			got = requestTimeout(ctx, &Config{})
+			return nil
+		}),
+	}
+	if err := s.attempt(context.Background(), &PubSubMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	// A sink timeout longer than the default must not be cut short by it
+	if got != 30*time.Second {
+		t.Errorf("request timeout = %s, want the sink's 30s", got)
+	}
+	if got := requestTimeout(context.Background(), &Config{}); got != defaultHTTPTimeout {
+		t.Errorf("request timeout without a sink timeout = %s, want %s", got, defaultHTTPTimeout)
+	}
+}
+
+func TestSinkRetryHonorsRetryAfter(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	// The two minutes the downstream asks for are capped by --retry-max-delay
+	cfg := &Config{URL: server.URL, MaxRetries: 1, RetryBaseDelay: time.Millisecond, RetryMaxDelay: 200 * time.Millisecond}
+	sinks, err := openSinks(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sinks.Close()
+
+	start := time.Now()
+	if err := sinks.Send(context.Background(), &PubSubMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("retry came after %s, want the 200ms cap instead of the backoff or the full Retry-After", elapsed)
+	}
+}
+
+func TestSinkRetryTotalTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		delay time.Duration
+	}{
+		{"stops retrying once the budget is spent", 0},
+		{"cuts off an attempt that outlasts the budget", 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				// The server only notices the client giving up once the body has been read
+				io.Copy(io.Discard, r.Body)
+				select {
+				case <-r.Context().Done():
+				case <-time.After(tt.delay):
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer server.Close()
+
+			// Plenty of retries remain, so only the total timeout can end the sending
+			cfg := &Config{URL: server.URL, MaxRetries: 1000, RetryBaseDelay: 10 * time.Millisecond, RetryMaxDelay: time.Second, RetryTotalTimeout: 200 * time.Millisecond}
+			sinks, err := openSinks(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sinks.Close()
+
+			start := time.Now()
+			if err := sinks.Send(context.Background(), &PubSubMessage{}); err == nil {
+				t.Fatal("Send() succeeded, want the 503 error")
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Send() took %s, want it to end with the 200ms budget", elapsed)
+			}
+			if got := attempts.Load(); got >= 1000 {
+				t.Errorf("made %d attempts, want the budget to stop retries", got)
+			}
+		})
+	}
+}
+
+func TestEventHubsAttributesCannotReplaceReservedHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	sink := &eventHubsSink{endpoint: server.URL + "/hub/messages", keyName: "send", key: "secret", client: server.Client(), cfg: &Config{}}
+	payload := &PubSubMessage{}
+	payload.Message.MessageID = "1"
+	payload.Message.OrderingKey = "customer-1"
+	payload.Message.Attributes = map[string]string{
+		"Authorization":    "stolen",
+		"content-type":     "text/plain",
+		"BrokerProperties": `{"PartitionKey":"other"}`,
+		"bad name":         "x",
+		"Region":           "eu",
+	}
+	if err := sink.Send(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if auth := got.Get("Authorization"); !strings.HasPrefix(auth, "SharedAccessSignature ") {
+		t.Errorf("Authorization = %q, want the SAS token", auth)
+	}
+	if ct := got.Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+		t.Errorf("Content-Type = %q, want the Event Hubs content type", ct)
+	}
+	if bp := got.Get("BrokerProperties"); bp != `{"PartitionKey":"customer-1"}` {
+		t.Errorf("BrokerProperties = %q, want the ordering key as partition key", bp)
+	}
+	if got.Get("Region") != "eu" {
+		t.Errorf("Region = %q, want the attribute as a property", got.Get("Region"))
+	}
+}
+
+func TestAuditRecordsNameBalancedURL(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	first := httptest.NewServer(handler)
+	defer first.Close()
+	second := httptest.NewServer(handler)
+	defer second.Close()
+
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	cfg := &Config{
+		URL:             first.URL,
+		BalanceTargets:  []BalanceTarget{{URL: first.URL, Weight: 1}, {URL: second.URL, Weight: 1}},
+		BalanceStrategy: balanceRoundRobin,
+		AuditLogFile:    auditFile,
+	}
+	sinks, err := openSinks(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := sinks.Send(context.Background(), &PubSubMessage{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sinks.Close()
+
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record auditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		targets[record.Target] = true
+	}
+	if !targets[first.URL] || !targets[second.URL] {
+		t.Errorf("audit targets = %v, want both %s and %s", targets, first.URL, second.URL)
+	}
+}
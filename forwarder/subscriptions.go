@@ -0,0 +1,209 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// SubscriptionConfig is one forwarder of a --config file, overriding the command-line settings it names
+type SubscriptionConfig struct {
+	// Project defaults to --project
+	Project      string `json:"project"`
+	Subscription string `json:"subscription"`
+	// URL defaults to --url
+	URL string `json:"url"`
+	// AdminAddr serves this forwarder's admin endpoints, forwarders without one sharing the --admin-addr server
+	AdminAddr string `json:"adminAddr"`
+	// Routes send messages matching an attribute to their own URL instead of URL
+	Routes []Route `json:"routes"`
+}
+
+// Route sends messages whose attribute matches to URL. An empty Value matches any message with the attribute.
+type Route struct {
+	Attribute string `json:"attribute"`
+	Value     string `json:"value"`
+	URL       string `json:"url"`
+}
+
+// Environment is the downstream of one deployment environment in a --config file, selected by --environment
+type Environment struct {
+	// URL replaces --url, while a forwarder's own url still takes precedence
+	URL string `json:"url"`
+	// Headers are set on each request in the "Name: value" form of --header, after the --header flags
+	Headers []string `json:"headers"`
+}
+
+// configFile is the layout of a --config file
+type configFile struct {
+	Forwarders   []SubscriptionConfig   `json:"forwarders"`
+	Environments map[string]Environment `json:"environments"`
+}
+
+// readConfigFile reads and parses a --config file, rejecting fields it does not know
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var file configFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// LoadEnvironment returns the environment called name from the environments of a --config file, so one file
+// can hold the downstream of every environment a binary is deployed to
+func LoadEnvironment(path, name string) (Environment, error) {
+	file, err := readConfigFile(path)
+	if err != nil {
+		return Environment{}, err
+	}
+	env, ok := file.Environments[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(file.Environments))
+		if len(names) == 0 {
+			return Environment{}, fmt.Errorf("environment %s is not defined in %s, which has no environments", name, path)
+		}
+		return Environment{}, fmt.Errorf("environment %s is not defined in %s, which has %s", name, path, strings.Join(names, ", "))
+	}
+	if env.URL != "" {
+		if parsed, err := url.Parse(env.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return Environment{}, fmt.Errorf("environment %s in %s has an invalid url %q: must be an http or https URL with a host", name, path, env.URL)
+		}
+	}
+	return env, nil
+}
+
+// LoadSubscriptions reads a JSON file holding a list of forwarders, one per subscription, for running several
+// subscriptions in one process. Forwarders without a project are given defaultProject.
+func LoadSubscriptions(path, defaultProject string) ([]SubscriptionConfig, error) {
+	file, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Forwarders) == 0 {
+		return nil, fmt.Errorf("config file %s defines no forwarders", path)
+	}
+
+	seen := make(map[string]bool)
+	for i := range file.Forwarders {
+		sub := &file.Forwarders[i]
+		if sub.Subscription == "" {
+			return nil, fmt.Errorf("forwarder %d in %s has no subscription", i+1, path)
+		}
+		// An entry naming the default project is the same subscription as one that leaves it out
+		if sub.Project == "" {
+			sub.Project = defaultProject
+		}
+		if seen[sub.Project+"/"+sub.Subscription] {
+			return nil, fmt.Errorf("subscription %s appears more than once in %s", sub.Subscription, path)
+		}
+		seen[sub.Project+"/"+sub.Subscription] = true
+		for _, route := range sub.Routes {
+			if route.Attribute == "" || route.URL == "" {
+				return nil, fmt.Errorf("route of subscription %s in %s needs an attribute and a url", sub.Subscription, path)
+			}
+			if parsed, err := url.Parse(route.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return nil, fmt.Errorf("route of subscription %s in %s has an invalid url %q: must be an http or https URL with a host", sub.Subscription, path, route.URL)
+			}
+		}
+	}
+	return file.Forwarders, nil
+}
+
+// configFor returns a copy of the command-line configuration with the forwarder's own settings applied
+func (s SubscriptionConfig) configFor(base *Config) *Config {
+	cfg := *base
+	cfg.Subscriptions = nil
+	cfg.Subscription = s.Subscription
+	if s.Project != "" {
+		cfg.Project = s.Project
+	}
+	if s.URL != "" {
+		cfg.URL = s.URL
+	}
+	// Only one server can listen on the command-line admin address, which RunSubscriptions shares instead
+	cfg.AdminAddr = s.AdminAddr
+	cfg.Routes = s.Routes
+	return &cfg
+}
+
+// routeURL returns the URL of the first route matching the message's attributes
+func routeURL(routes []Route, attributes map[string]string) (string, bool) {
+	for _, route := range routes {
+		value, ok := attributes[route.Attribute]
+		if ok && (route.Value == "" || route.Value == value) {
+			return route.URL, true
+		}
+	}
+	return "", false
+}
+
+// forwarderConfigs returns the configuration of each forwarder of cfg.Subscriptions. The forwarders share the
+// --min-interval gate, the --max-inflight-bytes budget and the --auto-concurrency limit, so those hold for the
+// process as a whole rather than once per subscription. The forwarders without an adminAddr of their own report
+// to the returned --admin-addr server, nil when there is none.
+func forwarderConfigs(cfg *Config) ([]*Config, *adminGroup) {
+	gate := newIntervalGate(cfg)
+	inflight := newInflightLimit(cfg)
+	limiter := newAIMDLimiter(cfg)
+	var admin *adminGroup
+	if cfg.AdminAddr != "" {
+		admin = &adminGroup{}
+	}
+	configs := make([]*Config, len(cfg.Subscriptions))
+	for i, sub := range cfg.Subscriptions {
+		subCfg := sub.configFor(cfg)
+		if admin != nil && subCfg.AdminAddr == "" {
+			subCfg.adminGroup = admin
+			admin.size++
+		}
+		// Every log line of the forwarder names its subscription, since the forwarders share the output
+		subCfg.logger = log.New(log.Writer(), fmt.Sprintf("[%s/%s] ", subCfg.Project, subCfg.Subscription), log.Flags()|log.Lmsgprefix)
+		subCfg.intervalGate = gate
+		subCfg.inflightLimit = inflight
+		subCfg.concurrencyLimiter = limiter
+		configs[i] = subCfg
+	}
+	if admin != nil && admin.size == 0 {
+		admin = nil
+	}
+	return configs, admin
+}
+
+// RunSubscriptions runs a forwarder for each of cfg.Subscriptions concurrently until ctx is cancelled. A
+// forwarder that fails is logged and the others keep running; the failures are returned once all have stopped.
+func RunSubscriptions(ctx context.Context, cfg *Config) error {
+	configs, admin := forwarderConfigs(cfg)
+	if admin != nil {
+		startAdminServer(ctx, cfg, admin.handlers(cfg.LastErrorsSize > 0))
+	}
+	errs := make([]error, len(configs))
+	var wg sync.WaitGroup
+	for i, subCfg := range configs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			subCfg.log().Printf("Forwarding to %s", subCfg.URL)
+			if err := Run(ctx, subCfg, nil); err != nil {
+				subCfg.log().Printf("Forwarder stopped: %v", err)
+				errs[i] = fmt.Errorf("subscription %s: %w", subCfg.Subscription, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
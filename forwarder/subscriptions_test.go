@@ -0,0 +1,181 @@
+package forwarder
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLoadSubscriptionsDefaultsProject(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"forwarders": [{"subscription": "orders"}, {"project": "other", "subscription": "orders"}]}`)
+	subs, err := LoadSubscriptions(path, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subs[0].Project != "main" || subs[1].Project != "other" {
+		t.Errorf("projects = %q and %q, want main and other", subs[0].Project, subs[1].Project)
+	}
+
+	// Leaving out the project names the same subscription as spelling out --project
+	write(`{"forwarders": [{"subscription": "orders"}, {"project": "main", "subscription": "orders"}]}`)
+	if _, err := LoadSubscriptions(path, "main"); err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Errorf("LoadSubscriptions() error = %v, want a duplicate subscription error", err)
+	}
+}
+
+func TestForwarderConfigsShareLimits(t *testing.T) {
+	cfg := &Config{
+		Project:            "main",
+		MinInterval:        time.Second,
+		MaxInflightBytes:   1 << 20,
+		AutoConcurrency:    true,
+		AutoConcurrencyMax: 8,
+		Subscriptions: []SubscriptionConfig{
+			{Project: "main", Subscription: "orders"},
+			{Project: "other", Subscription: "users"},
+		},
+	}
+	configs, _ := forwarderConfigs(cfg)
+	if len(configs) != 2 {
+		t.Fatalf("got %d configs, want 2", len(configs))
+	}
+	// Each forwarder would otherwise pace itself and together send twice per interval
+	if newIntervalGate(configs[0]) != newIntervalGate(configs[1]) {
+		t.Error("forwarders have their own --min-interval gates, want one shared gate")
+	}
+	// Process-wide limits would otherwise allow one budget per forwarder
+	first := newConsumer(configs[0], nil, newHeartbeat(log.Default()), nil, nil)
+	second := newConsumer(configs[1], nil, newHeartbeat(log.Default()), nil, nil)
+	if first.inflight == nil || first.inflight != second.inflight {
+		t.Error("forwarders have their own --max-inflight-bytes budgets, want one shared budget")
+	}
+	if first.limiter == nil || first.limiter != second.limiter {
+		t.Error("forwarders have their own --auto-concurrency limits, want one shared limit")
+	}
+	if got := configs[1].log().Prefix(); got != "[other/users] " {
+		t.Errorf("log prefix = %q, want %q", got, "[other/users] ")
+	}
+	if newIntervalGate(&Config{MinInterval: time.Second}) == newIntervalGate(&Config{MinInterval: time.Second}) {
+		t.Error("single forwarders share a gate, want one each")
+	}
+}
+
+func TestHandlerDurationLabeledBySubscription(t *testing.T) {
+	handlerDuration.Reset()
+	for _, sub := range []string{"orders", "users"} {
+		for range 3 {
+			handlerDuration.WithLabelValues(sub, "ack").Observe(0.1)
+		}
+	}
+	// One series per forwarder and outcome, however many messages were handled
+	if got := testutil.CollectAndCount(handlerDuration); got != 2 {
+		t.Errorf("handler duration has %d series, want 2", got)
+	}
+}
+
+func TestLoadEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{
+		"environments": {
+			"dev": {"url": "http://localhost:8080"},
+			"prod": {"url": "https://orders.example.com", "headers": ["X-Environment: prod"]},
+			"broken": {"url": "orders.example.com"}
+		},
+		"forwarders": [{"subscription": "orders"}]
+	}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := LoadEnvironment(path, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.URL != "https://orders.example.com" || len(env.Headers) != 1 || env.Headers[0] != "X-Environment: prod" {
+		t.Errorf("LoadEnvironment() = %+v, want the prod URL and header", env)
+	}
+	// The environments do not get in the way of the forwarders of the same file
+	if subs, err := LoadSubscriptions(path, "main"); err != nil || len(subs) != 1 {
+		t.Errorf("LoadSubscriptions() = %v, %v, want the one forwarder", subs, err)
+	}
+
+	if _, err := LoadEnvironment(path, "staging"); err == nil || !strings.Contains(err.Error(), "broken, dev, prod") {
+		t.Errorf("LoadEnvironment() error = %v, want the undefined environment named with the defined ones", err)
+	}
+	if _, err := LoadEnvironment(path, "broken"); err == nil || !strings.Contains(err.Error(), "invalid url") {
+		t.Errorf("LoadEnvironment() error = %v, want an invalid url error", err)
+	}
+}
+
+func TestSharedAdminServerCoversAllForwarders(t *testing.T) {
+	cfg := &Config{
+		Project:   "main",
+		AdminAddr: ":9090",
+		Subscriptions: []SubscriptionConfig{
+			{Subscription: "orders"},
+			{Subscription: "users"},
+			{Subscription: "audit", AdminAddr: ":9091"},
+		},
+	}
+	configs, admin := forwarderConfigs(cfg)
+	if admin == nil || admin.size != 2 {
+		t.Fatalf("shared admin server = %+v, want one for the 2 forwarders without their own adminAddr", admin)
+	}
+	if configs[2].adminGroup != nil || configs[2].AdminAddr != ":9091" {
+		t.Error("forwarder with its own adminAddr joined the shared admin server")
+	}
+	handlers := admin.handlers(false)
+	call := func(handler http.Handler, method, target string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec.Code
+	}
+
+	members := make(map[string]adminMember)
+	for _, subCfg := range configs[:2] {
+		member := adminMember{subscription: subCfg.Subscription, pause: &pauser{logger: log.Default()}, ready: &readiness{}}
+		members[subCfg.Subscription] = member
+		subCfg.adminGroup.join(member)
+	}
+	members["orders"].ready.receiving.Store(true)
+	// One forwarder that is not receiving makes the whole process not ready
+	if code := call(handlers.ready, http.MethodGet, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d with users not receiving, want 503", code)
+	}
+	members["users"].ready.receiving.Store(true)
+	if code := call(handlers.ready, http.MethodGet, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz = %d with both receiving, want 200", code)
+	}
+
+	if code := call(handlers.pause, http.MethodPost, "/pause?subscription=users"); code != http.StatusNoContent {
+		t.Fatalf("/pause?subscription=users = %d, want 204", code)
+	}
+	if members["orders"].pause.waiting() != nil || members["users"].pause.waiting() == nil {
+		t.Error("/pause?subscription=users did not pause only users")
+	}
+	call(handlers.pause, http.MethodPost, "/pause")
+	if members["orders"].pause.waiting() == nil {
+		t.Error("/pause did not pause every forwarder")
+	}
+	call(handlers.resume, http.MethodPost, "/resume")
+	if members["orders"].pause.waiting() != nil || members["users"].pause.waiting() != nil {
+		t.Error("/resume did not resume every forwarder")
+	}
+	if code := call(handlers.pause, http.MethodPost, "/pause?subscription=unknown"); code != http.StatusNotFound {
+		t.Errorf("/pause?subscription=unknown = %d, want 404", code)
+	}
+}
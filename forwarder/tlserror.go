@@ -0,0 +1,47 @@
+package forwarder
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// describeTLSError explains a TLS handshake failure with the details of the offending certificate when
+// available, reporting whether err was one. These failures usually need a human to renew or fix a
+// certificate rather than a retry.
+func describeTLSError(err error) (string, bool) {
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) && invalid.Cert != nil {
+		if invalid.Reason == x509.Expired {
+			return fmt.Sprintf("downstream certificate for %s expired or not yet valid (valid %s to %s)",
+				invalid.Cert.Subject.CommonName, invalid.Cert.NotBefore.UTC().Format(time.RFC3339),
+				invalid.Cert.NotAfter.UTC().Format(time.RFC3339)), true
+		}
+		return fmt.Sprintf("downstream certificate for %s is invalid", invalid.Cert.Subject.CommonName), true
+	}
+	var hostname x509.HostnameError
+	if errors.As(err, &hostname) && hostname.Certificate != nil {
+		return fmt.Sprintf("downstream certificate for %s does not match host %s, valid for %v",
+			hostname.Certificate.Subject.CommonName, hostname.Host, hostname.Certificate.DNSNames), true
+	}
+	var unknown x509.UnknownAuthorityError
+	if errors.As(err, &unknown) && unknown.Cert != nil {
+		return fmt.Sprintf("downstream certificate for %s is signed by unknown authority %s",
+			unknown.Cert.Subject.CommonName, unknown.Cert.Issuer.CommonName), true
+	}
+	var verification *tls.CertificateVerificationError
+	if errors.As(err, &verification) {
+		return "downstream certificate could not be verified", true
+	}
+	var alert tls.AlertError
+	if errors.As(err, &alert) {
+		return fmt.Sprintf("downstream rejected the TLS handshake (%v), check --tls-server-name and client certificates", alert), true
+	}
+	var record tls.RecordHeaderError
+	if errors.As(err, &record) {
+		return "downstream did not respond with TLS, check the URL scheme and port", true
+	}
+	return "", false
+}
@@ -0,0 +1,106 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// wasmTransform runs a user-provided WebAssembly module that rewrites the request for each message. The
+// module exports alloc(size i32) i32, returning a buffer for the input, and transform(ptr i32, len i32) i64,
+// which reads the JSON payload from the buffer and returns the location of its output packed as ptr<<32|len.
+// The output is a JSON object {"body": "...", "headers": {"Name": "value"}}.
+type wasmTransform struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	timeout  time.Duration
+}
+
+// wasmOutput is the request a transform module returns
+type wasmOutput struct {
+	Body    string            `json:"body"`
+	Headers map[string]string `json:"headers"`
+}
+
+// loadWASMTransform compiles the module at path, or returns nil when no module is configured
+func loadWASMTransform(ctx context.Context, cfg *Config) (*wasmTransform, error) {
+	if cfg.TransformWASM == "" {
+		return nil, nil
+	}
+	code, err := os.ReadFile(cfg.TransformWASM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WASM module %s: %w", cfg.TransformWASM, err)
+	}
+
+	// Closing on context done lets the per-invocation timeout interrupt a module stuck in a loop
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to compile WASM module %s: %w", cfg.TransformWASM, err)
+	}
+	for _, name := range []string{"alloc", "transform"} {
+		if _, ok := compiled.ExportedFunctions()[name]; !ok {
+			runtime.Close(ctx)
+			return nil, fmt.Errorf("invalid WASM module %s: missing exported function %s", cfg.TransformWASM, name)
+		}
+	}
+
+	return &wasmTransform{runtime: runtime, compiled: compiled, timeout: cfg.TransformTimeout}, nil
+}
+
+// run transforms the JSON payload in a fresh module instance, so invocations are isolated and can run
+// concurrently, bounded by the transform timeout
+func (w *wasmTransform) run(ctx context.Context, input []byte) (*wasmOutput, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	mod, err := w.runtime.InstantiateModule(ctx, w.compiled, wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate WASM module: %w", err)
+	}
+	defer mod.Close(ctx)
+
+	results, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("WASM alloc failed: %w", err)
+	}
+	ptr := uint32(results[0])
+	if !mod.Memory().Write(ptr, input) {
+		return nil, fmt.Errorf("WASM alloc returned an out of range buffer")
+	}
+
+	results, err = mod.ExportedFunction("transform").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("WASM transform failed: %w", err)
+	}
+	return readWASMOutput(mod.Memory(), results[0])
+}
+
+// readWASMOutput decodes the output located by the packed ptr<<32|len result
+func readWASMOutput(memory api.Memory, packed uint64) (*wasmOutput, error) {
+	data, ok := memory.Read(uint32(packed>>32), uint32(packed))
+	if !ok {
+		return nil, fmt.Errorf("WASM transform returned an out of range output")
+	}
+	var output wasmOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, fmt.Errorf("failed to parse WASM transform output: %w", err)
+	}
+	return &output, nil
+}
+
+// Close releases the runtime and compiled module
+func (w *wasmTransform) Close(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	return w.runtime.Close(ctx)
+}
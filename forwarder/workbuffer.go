@@ -0,0 +1,91 @@
+package forwarder
+
+import (
+	"context"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Overflow policies for a full work buffer
+const (
+	workBufferBlock = "block"
+	workBufferNack  = "nack"
+)
+
+// workItem is a received message waiting in the work buffer
+type workItem struct {
+	ctx context.Context
+	msg *pubsub.Message
+}
+
+// workBuffer is a bounded queue between Receive and the handlers, giving a hard limit on the number of
+// received messages held in memory waiting to be handled
+type workBuffer struct {
+	items    chan workItem
+	nack     bool
+	shutdown <-chan struct{}
+	wg       sync.WaitGroup
+	// depth and overflows are the buffer's metrics, labeled by its subscription
+	depth     prometheus.Gauge
+	overflows prometheus.Counter
+}
+
+// newWorkBuffer starts workers that take messages from a buffer of size messages and process them with handle.
+// A message still waiting in the buffer once ctx is cancelled is Nacked rather than handled.
+func newWorkBuffer(ctx context.Context, subscription string, size, workers int, overflow string, handle func(context.Context, *pubsub.Message)) *workBuffer {
+	b := &workBuffer{
+		items:     make(chan workItem, size),
+		nack:      overflow == workBufferNack,
+		shutdown:  ctx.Done(),
+		depth:     workBufferDepth.WithLabelValues(subscription),
+		overflows: workBufferOverflows.WithLabelValues(subscription),
+	}
+	for range workers {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			for item := range b.items {
+				b.depth.Set(float64(len(b.items)))
+				select {
+				case <-b.shutdown:
+					item.msg.Nack()
+				default:
+					handle(item.ctx, item.msg)
+				}
+			}
+		}()
+	}
+	return b
+}
+
+// add queues the message for a worker. When the buffer is full the message is Nacked immediately with the
+// nack policy, and otherwise waits for room.
+func (b *workBuffer) add(ctx context.Context, msg *pubsub.Message) {
+	item := workItem{ctx: ctx, msg: msg}
+	if b.nack {
+		select {
+		case b.items <- item:
+		default:
+			b.overflows.Inc()
+			msg.Nack()
+			return
+		}
+	} else {
+		select {
+		case b.items <- item:
+		case <-ctx.Done():
+			msg.Nack()
+			return
+		}
+	}
+	b.depth.Set(float64(len(b.items)))
+}
+
+// stop closes the buffer once Receive has returned and waits for the workers to finish
+func (b *workBuffer) stop() {
+	close(b.items)
+	b.wg.Wait()
+	b.depth.Set(0)
+}
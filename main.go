@@ -2,21 +2,23 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
+	"math"
+	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
-	"cloud.google.com/go/pubsub"
+	"github.com/UnitVectorY-Labs/pubsubmsgrestforwarder/forwarder"
 )
 
 var Version = "dev" // This will be set by the build systems to the release version
@@ -31,30 +33,231 @@ func buildVersionOutput(version string) string {
 	return fmt.Sprintf("%s (%s, %s/%s)", normalized, runtime.Version(), runtime.GOOS, runtime.GOARCH)
 }
 
-// Config holds the configuration parsed from command-line arguments
-type Config struct {
-	Project      string
-	Subscription string
-	URL          string
+// Exit codes returned for each class of failure so a supervisor can decide whether to restart or alert
+const (
+	exitConfigError           = 2
+	exitAuthError             = 3
+	exitSubscriptionNotFound  = 4
+	exitDownstreamUnreachable = 5
+	exitReceiveError          = 6
+	exitPubSubSetupError      = 7
+)
+
+// fatalf logs the message and exits with the given exit code
+func fatalf(code int, format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// exitCodeFor maps an error returned by forwarder.Run to the exit code for its failure class
+func exitCodeFor(err error) int {
+	switch {
+	case errors.Is(err, forwarder.ErrInvalidConfig):
+		return exitConfigError
+	case errors.Is(err, forwarder.ErrSubscriptionNotFound):
+		return exitSubscriptionNotFound
+	case forwarder.IsAuthError(err), errors.Is(err, forwarder.ErrNoCredentials), errors.Is(err, forwarder.ErrDownstreamAuth):
+		// Only a setup failure the API rejected as unauthenticated or denied is an auth problem, other setup
+		// failures fall through to the case below
+		return exitAuthError
+	case errors.Is(err, forwarder.ErrPubSubSetup):
+		return exitPubSubSetupError
+	case errors.Is(err, forwarder.ErrDownstreamUnreachable):
+		return exitDownstreamUnreachable
+	case errors.Is(err, forwarder.ErrReceive):
+		return exitReceiveError
+	}
+	return 1
+}
+
+// stringSliceFlag is a flag.Value that collects repeated occurrences of a flag
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
 }
 
-// PubSubMessage represents the transformed Pub/Sub message structure
-type PubSubMessage struct {
-	Message struct {
-		Attributes  map[string]string `json:"attributes"`
-		Data        string            `json:"data"`
-		MessageID   string            `json:"messageId"`
-		OrderingKey string            `json:"orderingKey,omitempty"`
-		PublishTime string            `json:"publishTime"`
-	} `json:"message"`
-	Subscription string `json:"subscription"`
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
 // parseFlags parses and validates comma`nd-line arguments
-func parseFlags() (*Config, error) {
+func parseFlags() (*forwarder.Config, error) {
 	project := flag.String("project", "", "GCP project ID (required)")
-	subscription := flag.String("subscription", "", "Pub/Sub subscription ID (required)")
-	url := flag.String("url", "http://localhost:8080", "URL to POST messages to (optional)")
+	subscription := flag.String("subscription", "", "Pub/Sub subscription ID (required unless --config is set)")
+	configFile := flag.String("config", "", "JSON file listing several subscriptions to forward from in one process, YAML is not accepted (optional)")
+	environment := flag.String("environment", "", "Environment of the --config file whose URL and headers are used, e.g. staging (optional)")
+	postURL := flag.String("url", "http://localhost:8080", "URL to POST messages to (optional)")
+	path := flag.String("path", "", "Path joined onto --url (optional)")
+	var headers stringSliceFlag
+	flag.Var(&headers, "header", "Request header \"Name: value\", the value may use templates such as {{.attributes.name}} (repeatable, optional)")
+	strictHeaders := flag.Bool("strict-headers", false, "Fail messages whose --header templates reference a missing attribute (optional)")
+	var sinks stringSliceFlag
+	flag.Var(&sinks, "sink", "Destination spec type[:key=value,...] with type http, kafka, gcs, file, exec, eventhubs or grpc (repeatable, default http)")
+	kafkaBrokers := flag.String("kafka-brokers", "", "Comma-separated Kafka broker addresses (required for --sink kafka)")
+	kafkaTopic := flag.String("kafka-topic", "", "Kafka topic to produce messages to (required for --sink kafka)")
+	outputFile := flag.String("output-file", "", "File to write JSON lines to (required for --sink file)")
+	rotateSize := flag.Int64("rotate-size", 0, "Rotate the output file after this many bytes, 0 disables (optional)")
+	rotateInterval := flag.Duration("rotate-interval", 0, "Rotate the output file after this long, 0 disables (optional)")
+	execCommand := flag.String("exec-command", "", "Shell command run per message with the JSON payload on stdin (required for --sink exec)")
+	execConcurrency := flag.Int("exec-concurrency", 4, "Maximum number of --exec-command processes running at once (optional)")
+	eventHubsConnectionString := flag.String("eventhubs-connection-string", "", "Event Hubs namespace connection string (required for --sink eventhubs)")
+	eventHubsName := flag.String("eventhubs-name", "", "Event Hub to send to, defaults to the connection string's EntityPath (optional)")
+	var keepAttributes stringSliceFlag
+	flag.Var(&keepAttributes, "keep-attribute", "Attribute to forward, all others are stripped (repeatable, optional)")
+	heartbeatInterval := flag.Duration("heartbeat-interval", 0, "Interval between heartbeat log lines, 0 disables (optional)")
+	startupProbeTimeout := flag.Duration("startup-probe-timeout", 0, "How long to retry reaching the URL at startup before exiting, 0 disables (optional)")
+	maxMessageAge := flag.Duration("max-message-age", 0, "Ack and drop messages older than this instead of forwarding, 0 disables (optional)")
+	includeSubscriptionID := flag.Bool("include-subscription-id", false, "Add the short subscription name as subscriptionId to the payload (optional)")
+	format := flag.String("format", "json", "Request body format: json or multipart (optional)")
+	dropOnAttribute := flag.String("drop-on-attribute", "", "Ack instead of Nack failed messages carrying this name=value attribute (optional)")
+	maxInflightBytes := flag.Int64("max-inflight-bytes", 0, "Maximum total bytes of message data being delivered at once, across all --config subscriptions, 0 disables (optional)")
+	schemaFile := flag.String("schema", "", "JSON Schema file that JSON message data is validated against (optional)")
+	schemaInvalidAction := flag.String("schema-invalid-action", "deadletter", "Messages failing schema validation: deadletter, drop or nack (optional)")
+	flag.BoolFunc("schema-drop-invalid", "Deprecated alias of --schema-invalid-action=drop (optional)", func(value string) error {
+		drop, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		log.Println("Warning: --schema-drop-invalid is deprecated, use --schema-invalid-action=drop")
+		if drop {
+			*schemaInvalidAction = "drop"
+		}
+		return nil
+	})
+	deadLetterTopic := flag.String("dead-letter-topic", "", "Topic ID that undeliverable messages are republished to (optional)")
+	adminAddr := flag.String("admin-addr", "", "Listen address for the admin server exposing /metrics, e.g. :9090 (optional)")
+	createSubscription := flag.Bool("create-subscription", false, "Create the subscription on --topic if it does not exist (optional)")
+	topic := flag.String("topic", "", "Topic ID the subscription is created on (required for --create-subscription)")
+	ackDeadline := flag.Duration("ack-deadline", 10*time.Second, "Ack deadline of a created subscription (optional)")
+	timeoutFromDeadline := flag.Bool("timeout-from-deadline", false, "Bound each POST by the remaining message deadline instead of the fixed timeout (optional)")
+	orderedWorkers := flag.Int("ordered-workers", 0, "Number of workers that ordering keys are hashed to, 0 disables (optional)")
+	attributesHeader := flag.String("attributes-header", "", "Header name to send the base64-encoded JSON attributes in, e.g. X-Pubsub-Attributes (optional)")
+	nackDelay := flag.Duration("nack-delay", 0, "Delay before a failed message is Nacked to postpone redelivery, 0 Nacks immediately (optional)")
+	chunked := flag.Bool("chunked", false, "Send the body with chunked transfer encoding instead of a Content-Length (optional)")
+	maxResponseBytes := flag.Int64("max-response-bytes", 64*1024, "Maximum bytes of each response body drained before closing it (optional)")
+	includeSubscriptionLabels := flag.Bool("include-subscription-labels", false, "Add the subscription's labels as subscriptionLabels to the payload (optional)")
+	alertWebhook := flag.String("alert-webhook", "", "URL POSTed a single alert after consecutive failures and on recovery (optional)")
+	alertFailureThreshold := flag.Int("alert-failure-threshold", 10, "Consecutive failures before the alert webhook fires (optional)")
+	pretty := flag.Bool("pretty", false, "Indent the JSON payload for readability (optional)")
+	deadlineHeader := flag.String("deadline-header", "", "Header name to send the request deadline in, e.g. X-Request-Deadline (optional)")
+	deadlineHeaderFormat := flag.String("deadline-header-format", "rfc3339", "Deadline header format: rfc3339 or epoch (optional)")
+	accept := flag.String("accept", "", "Accept header sent with each POST (optional)")
+	requireResponseField := flag.String("require-response-field", "", "Field, or field=value, the JSON response body must contain for the message to be Acked (optional)")
+	maxDeliveryAttempts := flag.Int("max-delivery-attempts", 0, "Dead-letter a message after this many failed deliveries counted in memory, 0 disables (optional)")
+	tlsServerName := flag.String("tls-server-name", "", "Server name for TLS SNI and certificate verification, overriding the URL host (optional)")
+	failoverURL := flag.String("failover-url", "", "URL POSTed to when delivery to the primary URL fails (optional)")
+	pauseMode := flag.String("pause-mode", "hold", "Handling of messages while paused via /pause: hold or nack (optional)")
+	autoConcurrency := flag.Bool("auto-concurrency", false, "Adapt concurrent deliveries to downstream 429 and 503 responses, one limit across all --config subscriptions (optional)")
+	autoConcurrencyMax := flag.Int("auto-concurrency-max", 16, "Upper bound on concurrent deliveries with --auto-concurrency (optional)")
+	maxConnsPerHost := flag.Int("max-conns-per-host", 0, "Maximum connections to each downstream host, 0 means no limit (optional)")
+	sampleRate := flag.Float64("sample-rate", 1, "Fraction of messages forwarded between 0 and 1, the rest are Acked unsent (optional)")
+	sampleDeterministic := flag.Bool("sample-deterministic", false, "Sample by a hash of the message ID so decisions are reproducible (optional)")
+	drainTimeout := flag.Duration("drain-timeout", 0, "Time in-flight messages may finish on shutdown before being Nacked, 0 Nacks immediately (optional)")
+	alwaysIncludeOrderingKey := flag.Bool("always-include-ordering-key", false, "Serialize orderingKey as \"\" instead of omitting it when not set (optional)")
+	proxyURL := flag.String("proxy-url", "", "Proxy all POSTs through this http, https or socks5 URL (optional)")
+	proxyHonorNoProxy := flag.Bool("proxy-honor-no-proxy", false, "Bypass --proxy-url for hosts listed in NO_PROXY (optional)")
+	perKeyRateLimit := flag.Float64("per-key-rate-limit", 0, "Maximum messages per second delivered for each ordering key, per subscription with --config, 0 disables (optional)")
+	perKeyBurst := flag.Int("per-key-burst", 1, "Messages of one ordering key delivered at once above --per-key-rate-limit (optional)")
+	skipExistenceCheck := flag.Bool("skip-existence-check", false, "Receive without verifying the subscription exists, for least-privilege service accounts (optional)")
+	transformWASM := flag.String("transform-wasm", "", "WebAssembly module that rewrites the request body and headers of each message (optional)")
+	transformTimeout := flag.Duration("transform-timeout", time.Second, "Maximum run time of each --transform-wasm invocation (optional)")
+	downstreamHangTimeout := flag.Duration("downstream-hang-timeout", 0, "Fail a POST when no response headers arrive within this long, 0 disables (optional)")
+	seekToTime := flag.String("seek-to-time", "", "RFC 3339 time to seek the subscription to at startup, replaying messages since then (optional)")
+	compression := flag.String("compression", "none", "Request body compression: none, gzip, deflate or br (optional)")
+	instanceID := flag.String("instance-id", "", "Identifier sent as X-Forwarder-Instance, defaults to the hostname (optional)")
+	reorderWindow := flag.Duration("reorder-window", 0, "Hold messages up to this long to forward them in publish time order, 0 disables (optional)")
+	reorderBufferSize := flag.Int("reorder-buffer-size", 100, "Maximum messages held for --reorder-window (optional)")
+	auditLogFile := flag.String("audit-log-file", "", "File receiving a JSON record of every forward attempt (optional)")
+	keepAlivePingInterval := flag.Duration("keepalive-ping-interval", 0, "Send a HEAD request to each HTTP downstream this often to keep connections warm, 0 disables (optional)")
+	keepAlivePingPath := flag.String("keepalive-ping-path", "/", "Path of the keep-alive HEAD request (optional)")
+	diskBufferDir := flag.String("disk-buffer-dir", "", "Directory that failed messages are buffered to and Acked, for redelivery on recovery (optional)")
+	diskBufferMaxBytes := flag.Int64("disk-buffer-max-bytes", 1<<30, "Maximum size of --disk-buffer-dir in bytes (optional)")
+	diskBufferRetryInterval := flag.Duration("disk-buffer-retry-interval", 5*time.Second, "How often redelivery of --disk-buffer-dir is attempted (optional)")
+	urlFromAttribute := flag.String("url-from-attribute", "", "Attribute whose value overrides the URL for the message, requires --allowed-hosts (optional)")
+	allowedHosts := flag.String("allowed-hosts", "", "Comma-separated hosts a --url-from-attribute URL may target (optional)")
+	onEmptyData := flag.String("on-empty-data", "forward", "Handling of messages without data: forward, drop or deadletter (optional)")
+	emptyDataPlaceholder := flag.String("empty-data-placeholder", "", "Data forwarded in place of empty message data (optional)")
+	transformWorkers := flag.Int("transform-workers", 0, "Workers that filter and transform messages ahead of --send-workers, 0 disables the pipeline (optional)")
+	sendWorkers := flag.Int("send-workers", 4, "Workers that deliver messages prepared by --transform-workers (optional)")
+	readinessCheckInterval := flag.Duration("readiness-check-interval", 30*time.Second, "How often /readyz verifies the subscription is reachable, 0 disables (optional)")
+	readinessFailureThreshold := flag.Int("readiness-failure-threshold", 3, "Consecutive failed reachability checks before /readyz reports not ready (optional)")
+	logRedeliveries := flag.Bool("log-redeliveries", false, "Log the delivery attempt of redelivered messages (optional)")
+	credentialsFile := flag.String("credentials-file", "", "Service account key file for Pub/Sub, falls back to GOOGLE_APPLICATION_CREDENTIALS and ADC (optional)")
+	orderRetryPolicy := flag.String("order-retry-policy", "skip", "After a message fails on an ordered worker: skip or halt its ordering key (optional)")
+	adminExposeConfig := flag.Bool("admin-expose-config", false, "Serve the redacted effective configuration at /config on the admin server (optional)")
+	deliverAfterAttribute := flag.String("deliver-after-attribute", "", "Attribute holding the earliest time to forward a message, RFC 3339 or Unix seconds (optional)")
+	maxDelay := flag.Duration("max-delay", 10*time.Minute, "Furthest in the future a message is held for --deliver-after-attribute, later ones are Nacked (optional)")
+	gcsCompression := flag.String("gcs-compression", "none", "Compression of objects written by the gcs sink: none or gzip (optional)")
+	maxLifetime := flag.Duration("max-lifetime", 0, "Shut down gracefully after running this long so the orchestrator restarts the process, 0 disables (optional)")
+	contentDigest := flag.String("content-digest", "", "Add a digest of the POST body: md5 for Content-MD5 or sha256 for an RFC 3230 Digest header (optional)")
+	minExtensionPeriod := flag.Duration("min-extension-period", 0, "Shortest single ack deadline extension, between 10s and 600s, 0 for the library default (optional)")
+	maxExtensionPeriod := flag.Duration("max-extension-period", 0, "Longest single ack deadline extension, between 10s and 600s, 0 for the library default (optional)")
+	cookieJar := flag.Bool("cookie-jar", false, "Keep cookies set by HTTP downstreams and send them on later POSTs (optional)")
+	loginURL := flag.String("login-url", "", "URL to post a login form to at startup and after a 401 for session cookies (optional)")
+	loginUsername := flag.String("login-username", "", "Username posted to --login-url (optional)")
+	loginPasswordFile := flag.String("login-password-file", "", "File holding the password posted to --login-url (optional)")
+	enrichmentFile := flag.String("enrichment-file", "", "JSON or CSV file mapping an attribute value to fields added to the message, reloaded on SIGHUP (optional)")
+	enrichmentAttribute := flag.String("enrichment-attribute", "", "Attribute whose value is looked up in --enrichment-file (optional)")
+	enrichmentTarget := flag.String("enrichment-target", "payload", "Where enrichment fields are added: payload or headers (optional)")
+	enrichmentMissing := flag.String("enrichment-missing", "pass", "Messages without an enrichment entry: pass or drop (optional)")
+	normalizeAttributeKeys := flag.String("normalize-attribute-keys", "", "Convert forwarded attribute keys to lower, upper, kebab or snake case (optional)")
+	expiryAttribute := flag.String("expiry-attribute", "", "Attribute holding a time after which the message is dropped, RFC 3339 or Unix seconds (optional)")
+	expiryMalformed := flag.String("expiry-malformed", "forward", "Messages with an unparseable --expiry-attribute: forward or drop (optional)")
+	lagMonitoringInterval := flag.Duration("lag-monitoring-interval", 0, "How often to export the oldest unacked message age from Cloud Monitoring, 0 disables (optional)")
+	minInterval := flag.Duration("min-interval", 0, "Least time between the starts of consecutive deliveries, across all --config subscriptions, 0 disables (optional)")
+	correlationIDSource := flag.String("correlation-id-source", "", "Where the correlation ID is read: attribute:name or jsonpath:$.path into the data (optional)")
+	correlationIDHeader := flag.String("correlation-id-header", "", "HTTP header the correlation ID is sent in (optional)")
+	correlationIDFallback := flag.String("correlation-id-fallback", "messageid", "Correlation ID when the source yields none: messageid or none (optional)")
+	onPayloadTooLarge := flag.String("on-payload-too-large", "deadletter", "Messages rejected with 413 Payload Too Large: deadletter or drop (optional)")
+	drainNackWaiting := flag.Bool("drain-nack-waiting", true, "On shutdown, Nack messages held by a Nack delay, pause or schedule at once instead of after --drain-timeout (optional)")
+	flattenAttributes := flag.Bool("flatten-attributes", false, "Promote attributes to top-level payload fields instead of message.attributes (optional)")
+	flattenAttributesPrefix := flag.String("flatten-attributes-prefix", "", "Prefix for the field names of flattened attributes, e.g. attr_ (optional)")
+	retryMaxDelay := flag.Duration("retry-max-delay", 30*time.Second, "Longest Retry-After delay honored before a sink retry (optional)")
+	maxRetries := flag.Int("max-retries", 3, "How many more times a failed send is attempted, for sinks without a retries option (optional)")
+	retryBaseDelay := flag.Duration("retry-base-delay", 500*time.Millisecond, "Delay before the first retry, doubling for each further retry, for sinks without a backoff option (optional)")
+	retryTotalTimeout := flag.Duration("retry-total-timeout", 0, "Total time a sink may spend on a message across all attempts and retries, 0 disables (optional)")
+	workBufferSize := flag.Int("work-buffer-size", 0, "Received messages that can wait for a handler in a bounded buffer, 0 disables (optional)")
+	workBufferOverflow := flag.String("work-buffer-overflow", "block", "When the work buffer is full: block to stop pulling or nack to Nack new messages (optional)")
+	methodFromAttribute := flag.String("method-from-attribute", "", "Attribute whose value is the HTTP method for the message, from --allowed-methods (optional)")
+	allowedMethods := flag.String("allowed-methods", "POST,PUT,PATCH,DELETE", "Comma-separated HTTP methods --method-from-attribute may select (optional)")
+	invalidMethodAction := flag.String("invalid-method-action", "default", "When the method attribute is not allowed: default to POST or deadletter (optional)")
+	signingSecret := flag.String("signing-secret", "", "Secret for an HMAC-SHA256 signature of each request body (optional)")
+	signatureScheme := flag.String("signature-scheme", "generic", "Format of the signature header: generic, stripe or github (optional)")
+	signatureHeader := flag.String("signature-header", "", "Name of the signature header, defaults to the one the scheme uses (optional)")
+	memLimit := flag.Int64("mem-limit", 0, "Soft memory limit in bytes for the Go runtime, also bounding the data pulled from the subscription, 0 disables (optional)")
+	subscriptionHeader := flag.String("subscription-header", "", "Request header carrying the source subscription, e.g. X-Subscription (optional)")
+	subscriptionHeaderFormat := flag.String("subscription-header-format", "short", "Value of --subscription-header: short for the subscription ID or full for its resource path (optional)")
+	parseJSONAttributes := flag.String("parse-json-attributes", "", "Comma-separated attributes whose JSON string values are embedded as nested values (optional)")
+	lastErrorsSize := flag.Int("last-errors-size", 20, "Recent delivery failures served by the admin server's /lasterrors endpoint, 0 disables (optional)")
+	var redactJSONPaths stringSliceFlag
+	flag.Var(&redactJSONPaths, "redact-jsonpath", "JSONPath into JSON message data, e.g. $.customer.ssn, whose value is masked before forwarding (repeatable, optional)")
+	redactMask := flag.String("redact-mask", "[REDACTED]", "String that replaces each --redact-jsonpath value (optional)")
+	startupJitter := flag.Duration("startup-jitter", 0, "Maximum random delay before receiving starts, spreading replica startups, 0 disables (optional)")
+	decodeFormat := flag.String("decode-format", "", "Convert message data from avro or protobuf to JSON before forwarding (optional)")
+	decodeSchema := flag.String("decode-schema", "", "Avro schema file or Protobuf descriptor set file for --decode-format (optional)")
+	decodeMessageType := flag.String("decode-message-type", "", "Fully qualified Protobuf message type of the data (required for --decode-format protobuf)")
+	decodeSchemaRegistryURL := flag.String("decode-schema-registry-url", "", "Schema registry Avro schemas are fetched from by the ID in --decode-schema-id-attribute (optional)")
+	decodeSchemaIDAttribute := flag.String("decode-schema-id-attribute", "schema-id", "Attribute holding the registry schema ID of the message (optional)")
+	var balanceURLs stringSliceFlag
+	flag.Var(&balanceURLs, "balance-url", "Equivalent downstream URL=weight the HTTP sink spreads messages across instead of --url (repeatable, optional)")
+	balanceStrategy := flag.String("balance-strategy", "weighted", "How each message's --balance-url is chosen: weighted at random or round-robin (optional)")
+	minTLSVersion := flag.String("min-tls-version", "1.2", "Lowest TLS version accepted from downstreams: 1.2 or 1.3 (optional)")
+	keySource := flag.String("key-source", "orderingKey", "Routing key of keyed sinks such as Kafka: orderingKey, attribute:name or jsonpath:$.path into the data (optional)")
+	stateFile := flag.String("state-file", "", "Local file --max-delivery-attempts counts are saved to so they survive restarts (optional)")
+	http2StrictStreamLimit := flag.Bool("http2-strict-stream-limit", false, "Queue requests once an HTTP/2 downstream's concurrent stream limit is reached instead of opening more connections (optional)")
+	http2PingInterval := flag.Duration("http2-ping-interval", 0, "Ping an HTTP/2 connection idle for this long to detect a dead connection, 0 disables (optional)")
+	http2PingTimeout := flag.Duration("http2-ping-timeout", 15*time.Second, "Close an HTTP/2 connection whose ping is not answered within this long (optional)")
+	idleKeepAliveInterval := flag.Duration("idle-keepalive-interval", 0, "POST --idle-keepalive-message to an HTTP downstream that has been sent nothing for this long, 0 disables (optional)")
+	idleKeepAliveMessage := flag.String("idle-keepalive-message", `{"keepalive":true}`, "Marker body of idle keep-alive messages, sent with X-Forwarder-Keepalive: true (optional)")
+	authAudience := flag.String("auth-audience", "", "Attach a Google-signed OIDC identity token for this audience to each request, usually the URL's scheme and host (optional)")
+	authServiceAccount := flag.String("auth-service-account", "", "Service account email impersonated to mint --auth-audience tokens, like a push subscription (optional)")
+	tokenRefreshMargin := flag.Duration("token-refresh-margin", time.Minute, "How long before expiry a cached --auth-audience token is replaced (optional)")
+	captureFile := flag.String("capture-file", "", "File every received message is appended to as a JSON line, for --replay (optional)")
+	replayFile := flag.String("replay", "", "Deliver the messages of a --capture-file and exit, without connecting to Pub/Sub (optional)")
+	var replayMessageIDs stringSliceFlag
+	flag.Var(&replayMessageIDs, "replay-message-id", "Only replay the message with this ID (repeatable, optional)")
 	showVersion := flag.Bool("version", false, "Print version")
 
 	flag.Parse()
@@ -64,115 +267,772 @@ func parseFlags() (*Config, error) {
 		os.Exit(0)
 	}
 
-	if *project == "" {
-		return nil, fmt.Errorf("missing required argument: --project")
+	var subscriptions []forwarder.SubscriptionConfig
+	if *configFile != "" {
+		var err error
+		if subscriptions, err = forwarder.LoadSubscriptions(*configFile, *project); err != nil {
+			return nil, err
+		}
+		if *subscription != "" {
+			return nil, fmt.Errorf("invalid --subscription: cannot be combined with --config, which lists the subscriptions")
+		}
+		for _, sub := range subscriptions {
+			if sub.Project == "" && *project == "" {
+				return nil, fmt.Errorf("missing required argument: --project, for subscription %s of --config without a project", sub.Subscription)
+			}
+		}
+	} else if *replayFile == "" {
+		if *project == "" {
+			return nil, fmt.Errorf("missing required argument: --project")
+		}
+		if *subscription == "" {
+			return nil, fmt.Errorf("missing required argument: --subscription")
+		}
 	}
-	if *subscription == "" {
-		return nil, fmt.Errorf("missing required argument: --subscription")
+
+	// The environment's downstream replaces the flags, so one config file serves every deployment
+	if *environment != "" {
+		if *configFile == "" {
+			return nil, fmt.Errorf("invalid --environment: requires --config, which defines the environments")
+		}
+		env, err := forwarder.LoadEnvironment(*configFile, *environment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --environment: %w", err)
+		}
+		if env.URL != "" {
+			*postURL = env.URL
+		}
+		headers = append(headers, env.Headers...)
 	}
 
-	return &Config{
-		Project:      *project,
-		Subscription: *subscription,
-		URL:          *url,
-	}, nil
-}
+	target := *postURL
+	if *path != "" {
+		joined, err := url.JoinPath(target, *path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --url %q: %w", target, err)
+		}
+		target = joined
+	}
 
-// setupPubSubClient initializes the Pub/Sub client and subscription
-func setupPubSubClient(ctx context.Context, cfg *Config) (*pubsub.Client, *pubsub.Subscription, error) {
-	client, err := pubsub.NewClient(ctx, cfg.Project)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	if *format != "json" && *format != "multipart" {
+		return nil, fmt.Errorf("invalid --format %q: must be json or multipart", *format)
 	}
 
-	sub := client.Subscription(cfg.Subscription)
-	sub.ReceiveSettings.MaxOutstandingMessages = 1
-	exists, err := sub.Exists(ctx)
-	if err != nil {
-		client.Close()
-		return nil, nil, fmt.Errorf("failed to verify subscription existence: %w", err)
+	if *orderedWorkers < 0 {
+		return nil, fmt.Errorf("invalid --ordered-workers %d: must not be negative", *orderedWorkers)
 	}
-	if !exists {
-		client.Close()
-		return nil, nil, fmt.Errorf("subscription %s does not exist", cfg.Subscription)
+
+	if *createSubscription {
+		if *topic == "" {
+			return nil, fmt.Errorf("missing required argument for --create-subscription: --topic")
+		}
+		if *ackDeadline < 10*time.Second || *ackDeadline > 600*time.Second {
+			return nil, fmt.Errorf("invalid --ack-deadline %s: must be between 10s and 600s", *ackDeadline)
+		}
 	}
 
-	log.Printf("Connected to Pub/Sub subscription: %s", cfg.Subscription)
-	return client, sub, nil
-}
+	var dropName, dropValue string
+	if *dropOnAttribute != "" {
+		var ok bool
+		dropName, dropValue, ok = strings.Cut(*dropOnAttribute, "=")
+		if !ok || dropName == "" {
+			return nil, fmt.Errorf("invalid --drop-on-attribute %q: must be name=value", *dropOnAttribute)
+		}
+	}
 
-// transformMessage converts a Pub/Sub message into the desired JSON structure
-func transformMessage(msg *pubsub.Message, cfg *Config) *PubSubMessage {
-	transformed := &PubSubMessage{}
-	transformed.Message.Attributes = msg.Attributes
-	transformed.Message.Data = base64.StdEncoding.EncodeToString(msg.Data)
-	transformed.Message.MessageID = msg.ID
-	transformed.Message.OrderingKey = msg.OrderingKey
-	transformed.Message.PublishTime = msg.PublishTime.Format(time.RFC3339)
-	transformed.Subscription = fmt.Sprintf("projects/%s/subscriptions/%s", cfg.Project, cfg.Subscription)
-	return transformed
-}
+	if len(sinks) == 0 {
+		sinks = stringSliceFlag{"http"}
+	}
+	var specs []forwarder.SinkSpec
+	var brokers []string
+	for _, broker := range strings.Split(*kafkaBrokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	for _, value := range sinks {
+		spec, err := forwarder.ParseSinkSpec(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --sink: %w", err)
+		}
+		switch spec.Type {
+		case "http":
+			sinkURL := target
+			if spec.Options["url"] != "" {
+				sinkURL = spec.Options["url"]
+			}
+			parsed, err := url.Parse(sinkURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid URL %q: %w", sinkURL, err)
+			}
+			if parsed.Scheme == "" || parsed.Host == "" {
+				return nil, fmt.Errorf("invalid URL %q: must include a scheme and host", sinkURL)
+			}
+			if *tlsServerName != "" && parsed.Scheme != "https" {
+				return nil, fmt.Errorf("invalid --tls-server-name: URL %q must use https", sinkURL)
+			}
+		case "kafka":
+			if len(brokers) == 0 {
+				return nil, fmt.Errorf("missing required argument for --sink kafka: --kafka-brokers")
+			}
+			if *kafkaTopic == "" && spec.Options["topic"] == "" {
+				return nil, fmt.Errorf("missing required argument for --sink kafka: --kafka-topic")
+			}
+		case "file":
+			if *outputFile == "" && spec.Options["path"] == "" {
+				return nil, fmt.Errorf("missing required argument for --sink file: --output-file")
+			}
+		case "exec":
+			if *execCommand == "" {
+				return nil, fmt.Errorf("missing required argument for --sink exec: --exec-command")
+			}
+		case "eventhubs":
+			if *eventHubsConnectionString == "" {
+				return nil, fmt.Errorf("missing required argument for --sink eventhubs: --eventhubs-connection-string")
+			}
+		case "grpc":
+			plaintext := spec.Options["plaintext"]
+			if plaintext != "" && plaintext != "true" && plaintext != "false" {
+				return nil, fmt.Errorf("invalid --sink %q: grpc plaintext option must be true or false", value)
+			}
+			if *tlsServerName != "" && plaintext == "true" {
+				return nil, fmt.Errorf("invalid --tls-server-name: grpc target %q is plaintext", spec.Options["target"])
+			}
+		}
+		specs = append(specs, spec)
+	}
 
-// sendPOST sends the transformed message to the specified URL via HTTP POST
-func sendPOST(url string, payload *PubSubMessage) error {
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal JSON payload: %w", err)
+	if *alertWebhook != "" && *alertFailureThreshold < 1 {
+		return nil, fmt.Errorf("invalid --alert-failure-threshold %d: must be at least 1", *alertFailureThreshold)
 	}
 
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(jsonData)))
-	if err != nil {
-		return fmt.Errorf("failed to create POST request: %w", err)
+	if *deadlineHeaderFormat != "rfc3339" && *deadlineHeaderFormat != "epoch" {
+		return nil, fmt.Errorf("invalid --deadline-header-format %q: must be rfc3339 or epoch", *deadlineHeaderFormat)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	if *rotateSize < 0 || *rotateInterval < 0 {
+		return nil, fmt.Errorf("invalid rotation: --rotate-size and --rotate-interval must not be negative")
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("POST request failed: %w", err)
+
+	var responseField, responseValue string
+	if *requireResponseField != "" {
+		responseField, responseValue, _ = strings.Cut(*requireResponseField, "=")
+		if responseField == "" {
+			return nil, fmt.Errorf("invalid --require-response-field %q: must be field or field=value", *requireResponseField)
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		log.Println("Message processed successfully.")
-	} else {
-		return fmt.Errorf("failed to process message. HTTP Status: %s", resp.Status)
+	if *maxDeliveryAttempts < 0 {
+		return nil, fmt.Errorf("invalid --max-delivery-attempts %d: must not be negative", *maxDeliveryAttempts)
+	}
+	if *maxDeliveryAttempts > 0 && *deadLetterTopic == "" {
+		return nil, fmt.Errorf("missing required argument for --max-delivery-attempts: --dead-letter-topic")
 	}
 
-	return nil
-}
+	if *execConcurrency < 1 {
+		return nil, fmt.Errorf("invalid --exec-concurrency %d: must be at least 1", *execConcurrency)
+	}
 
-// consumeMessages continuously receives and processes Pub/Sub messages
-func consumeMessages(ctx context.Context, sub *pubsub.Subscription, cfg *Config) error {
-	err := sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-		transformed := transformMessage(msg, cfg)
-		err := sendPOST(cfg.URL, transformed)
+	if *failoverURL != "" {
+		parsed, err := url.Parse(*failoverURL)
 		if err != nil {
-			log.Printf("Error processing message ID %s: %v", msg.ID, err)
-			// Nack the message to allow redelivery
-			msg.Nack()
-			return
+			return nil, fmt.Errorf("invalid --failover-url %q: %w", *failoverURL, err)
 		}
-		// Acknowledge the message upon successful processing
-		msg.Ack()
-	})
+		if parsed.Scheme == "" || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid --failover-url %q: must include a scheme and host", *failoverURL)
+		}
+	}
 
-	if err != nil && err != context.Canceled {
-		return fmt.Errorf("error receiving messages: %w", err)
+	if *pauseMode != "hold" && *pauseMode != "nack" {
+		return nil, fmt.Errorf("invalid --pause-mode %q: must be hold or nack", *pauseMode)
 	}
 
-	return nil
+	if *autoConcurrency && *autoConcurrencyMax < 1 {
+		return nil, fmt.Errorf("invalid --auto-concurrency-max %d: must be at least 1", *autoConcurrencyMax)
+	}
+
+	if *maxConnsPerHost < 0 {
+		return nil, fmt.Errorf("invalid --max-conns-per-host %d: must not be negative", *maxConnsPerHost)
+	}
+
+	if *sampleRate < 0 || *sampleRate > 1 {
+		return nil, fmt.Errorf("invalid --sample-rate %g: must be between 0 and 1", *sampleRate)
+	}
+
+	if *drainTimeout < 0 {
+		return nil, fmt.Errorf("invalid --drain-timeout %s: must not be negative", *drainTimeout)
+	}
+
+	var parsedHeaders []forwarder.Header
+	for _, value := range headers {
+		header, err := forwarder.ParseHeader(value, *strictHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --header: %w", err)
+		}
+		parsedHeaders = append(parsedHeaders, header)
+	}
+
+	var proxy *url.URL
+	if *proxyURL != "" {
+		var err error
+		proxy, err = url.Parse(*proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --proxy-url %q: %w", *proxyURL, err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("invalid --proxy-url %q: scheme must be http, https or socks5", *proxyURL)
+		}
+		if proxy.Host == "" {
+			return nil, fmt.Errorf("invalid --proxy-url %q: must include a host", *proxyURL)
+		}
+	}
+
+	if *perKeyRateLimit < 0 {
+		return nil, fmt.Errorf("invalid --per-key-rate-limit %g: must not be negative", *perKeyRateLimit)
+	}
+	if *perKeyRateLimit > 0 && *perKeyBurst < 1 {
+		return nil, fmt.Errorf("invalid --per-key-burst %d: must be at least 1", *perKeyBurst)
+	}
+
+	if *skipExistenceCheck && (*createSubscription || *includeSubscriptionLabels) {
+		return nil, fmt.Errorf("invalid --skip-existence-check: cannot be combined with --create-subscription or --include-subscription-labels")
+	}
+
+	if *transformWASM != "" && *transformTimeout <= 0 {
+		return nil, fmt.Errorf("invalid --transform-timeout %s: must be positive", *transformTimeout)
+	}
+
+	if *downstreamHangTimeout < 0 {
+		return nil, fmt.Errorf("invalid --downstream-hang-timeout %s: must not be negative", *downstreamHangTimeout)
+	}
+
+	var seekTime time.Time
+	if *seekToTime != "" {
+		var err error
+		seekTime, err = time.Parse(time.RFC3339, *seekToTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --seek-to-time %q: must be an RFC 3339 time: %w", *seekToTime, err)
+		}
+	}
+
+	switch *compression {
+	case "none", "gzip", "deflate", "br":
+	default:
+		return nil, fmt.Errorf("invalid --compression %q: must be none, gzip, deflate or br", *compression)
+	}
+
+	if *instanceID == "" {
+		if hostname, err := os.Hostname(); err == nil {
+			*instanceID = hostname
+		}
+	}
+
+	if *reorderWindow < 0 {
+		return nil, fmt.Errorf("invalid --reorder-window %s: must not be negative", *reorderWindow)
+	}
+	if *reorderWindow > 0 {
+		if *reorderBufferSize < 1 {
+			return nil, fmt.Errorf("invalid --reorder-buffer-size %d: must be at least 1", *reorderBufferSize)
+		}
+		if *orderedWorkers > 0 {
+			return nil, fmt.Errorf("invalid --reorder-window: cannot be combined with --ordered-workers")
+		}
+	}
+
+	if *keepAlivePingInterval < 0 {
+		return nil, fmt.Errorf("invalid --keepalive-ping-interval %s: must not be negative", *keepAlivePingInterval)
+	}
+	if !strings.HasPrefix(*keepAlivePingPath, "/") {
+		return nil, fmt.Errorf("invalid --keepalive-ping-path %q: must start with /", *keepAlivePingPath)
+	}
+
+	if *diskBufferDir != "" && (*diskBufferMaxBytes <= 0 || *diskBufferRetryInterval <= 0) {
+		return nil, fmt.Errorf("invalid disk buffer: --disk-buffer-max-bytes and --disk-buffer-retry-interval must be positive")
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(*allowedHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	if *urlFromAttribute != "" && len(hosts) == 0 {
+		return nil, fmt.Errorf("missing required argument for --url-from-attribute: --allowed-hosts")
+	}
+
+	switch *onEmptyData {
+	case "forward", "drop", "deadletter":
+	default:
+		return nil, fmt.Errorf("invalid --on-empty-data %q: must be forward, drop or deadletter", *onEmptyData)
+	}
+
+	if *transformWorkers < 0 {
+		return nil, fmt.Errorf("invalid --transform-workers %d: must not be negative", *transformWorkers)
+	}
+	if *transformWorkers > 0 {
+		if *sendWorkers < 1 {
+			return nil, fmt.Errorf("invalid --send-workers %d: must be at least 1", *sendWorkers)
+		}
+		if *orderedWorkers > 0 || *reorderWindow > 0 {
+			return nil, fmt.Errorf("invalid --transform-workers: cannot be combined with --ordered-workers or --reorder-window")
+		}
+	}
+
+	if *readinessCheckInterval < 0 {
+		return nil, fmt.Errorf("invalid --readiness-check-interval %s: must not be negative", *readinessCheckInterval)
+	}
+	if *readinessFailureThreshold < 1 {
+		return nil, fmt.Errorf("invalid --readiness-failure-threshold %d: must be at least 1", *readinessFailureThreshold)
+	}
+
+	switch *orderRetryPolicy {
+	case "skip":
+	case "halt":
+		if *orderedWorkers == 0 {
+			return nil, fmt.Errorf("invalid --order-retry-policy halt: requires --ordered-workers")
+		}
+	default:
+		return nil, fmt.Errorf("invalid --order-retry-policy %q: must be skip or halt", *orderRetryPolicy)
+	}
+
+	if *adminExposeConfig && *adminAddr == "" {
+		return nil, fmt.Errorf("invalid --admin-expose-config: requires --admin-addr")
+	}
+
+	if *maxDelay <= 0 {
+		return nil, fmt.Errorf("invalid --max-delay %s: must be positive", *maxDelay)
+	}
+
+	if *gcsCompression != "none" && *gcsCompression != "gzip" {
+		return nil, fmt.Errorf("invalid --gcs-compression %q: must be none or gzip", *gcsCompression)
+	}
+
+	if *maxLifetime < 0 {
+		return nil, fmt.Errorf("invalid --max-lifetime %s: must not be negative", *maxLifetime)
+	}
+
+	if *contentDigest != "" && *contentDigest != "md5" && *contentDigest != "sha256" {
+		return nil, fmt.Errorf("invalid --content-digest %q: must be md5 or sha256", *contentDigest)
+	}
+
+	if *minExtensionPeriod != 0 && (*minExtensionPeriod < 10*time.Second || *minExtensionPeriod > 600*time.Second) {
+		return nil, fmt.Errorf("invalid --min-extension-period %s: must be 0 or between 10s and 600s", *minExtensionPeriod)
+	}
+	if *maxExtensionPeriod != 0 && (*maxExtensionPeriod < 10*time.Second || *maxExtensionPeriod > 600*time.Second) {
+		return nil, fmt.Errorf("invalid --max-extension-period %s: must be 0 or between 10s and 600s", *maxExtensionPeriod)
+	}
+	if *minExtensionPeriod > 0 && *maxExtensionPeriod > 0 && *minExtensionPeriod > *maxExtensionPeriod {
+		return nil, fmt.Errorf("invalid --min-extension-period %s: must not exceed --max-extension-period %s", *minExtensionPeriod, *maxExtensionPeriod)
+	}
+
+	if *loginURL != "" && (*loginUsername == "" || *loginPasswordFile == "") {
+		return nil, fmt.Errorf("invalid --login-url: requires --login-username and --login-password-file")
+	}
+
+	if *enrichmentFile != "" && *enrichmentAttribute == "" {
+		return nil, fmt.Errorf("invalid --enrichment-file: requires --enrichment-attribute")
+	}
+	if *enrichmentTarget != "payload" && *enrichmentTarget != "headers" {
+		return nil, fmt.Errorf("invalid --enrichment-target %q: must be payload or headers", *enrichmentTarget)
+	}
+	if *enrichmentMissing != "pass" && *enrichmentMissing != "drop" {
+		return nil, fmt.Errorf("invalid --enrichment-missing %q: must be pass or drop", *enrichmentMissing)
+	}
+
+	switch *normalizeAttributeKeys {
+	case "", "lower", "upper", "kebab", "snake":
+	default:
+		return nil, fmt.Errorf("invalid --normalize-attribute-keys %q: must be lower, upper, kebab or snake", *normalizeAttributeKeys)
+	}
+
+	if *expiryMalformed != "forward" && *expiryMalformed != "drop" {
+		return nil, fmt.Errorf("invalid --expiry-malformed %q: must be forward or drop", *expiryMalformed)
+	}
+
+	if *lagMonitoringInterval < 0 {
+		return nil, fmt.Errorf("invalid --lag-monitoring-interval %s: must not be negative", *lagMonitoringInterval)
+	}
+
+	switch *schemaInvalidAction {
+	case "deadletter", "drop", "nack":
+	default:
+		return nil, fmt.Errorf("invalid --schema-invalid-action %q: must be deadletter, drop or nack", *schemaInvalidAction)
+	}
+
+	if *minInterval < 0 {
+		return nil, fmt.Errorf("invalid --min-interval %s: must not be negative", *minInterval)
+	}
+
+	var correlationSource forwarder.CorrelationSource
+	if *correlationIDSource != "" {
+		if *correlationIDHeader == "" {
+			return nil, fmt.Errorf("invalid --correlation-id-source: requires --correlation-id-header")
+		}
+		var err error
+		correlationSource, err = forwarder.ParseCorrelationSource(*correlationIDSource)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --correlation-id-source: %w", err)
+		}
+	}
+	if *correlationIDFallback != "messageid" && *correlationIDFallback != "none" {
+		return nil, fmt.Errorf("invalid --correlation-id-fallback %q: must be messageid or none", *correlationIDFallback)
+	}
+
+	if *onPayloadTooLarge != "deadletter" && *onPayloadTooLarge != "drop" {
+		return nil, fmt.Errorf("invalid --on-payload-too-large %q: must be deadletter or drop", *onPayloadTooLarge)
+	}
+
+	if *retryMaxDelay <= 0 {
+		return nil, fmt.Errorf("invalid --retry-max-delay %s: must be positive", *retryMaxDelay)
+	}
+	if *maxRetries < 0 {
+		return nil, fmt.Errorf("invalid --max-retries %d: must not be negative", *maxRetries)
+	}
+	if *retryBaseDelay <= 0 {
+		return nil, fmt.Errorf("invalid --retry-base-delay %s: must be positive", *retryBaseDelay)
+	}
+	if *retryTotalTimeout < 0 {
+		return nil, fmt.Errorf("invalid --retry-total-timeout %s: must not be negative", *retryTotalTimeout)
+	}
+
+	if *workBufferSize < 0 {
+		return nil, fmt.Errorf("invalid --work-buffer-size %d: must not be negative", *workBufferSize)
+	}
+	if *workBufferOverflow != "block" && *workBufferOverflow != "nack" {
+		return nil, fmt.Errorf("invalid --work-buffer-overflow %q: must be block or nack", *workBufferOverflow)
+	}
+
+	var methods []string
+	for _, method := range strings.Split(*allowedMethods, ",") {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			if strings.Trim(method, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+				return nil, fmt.Errorf("invalid --allowed-methods %q: %q is not an HTTP method", *allowedMethods, method)
+			}
+			methods = append(methods, method)
+		}
+	}
+	if *methodFromAttribute != "" && len(methods) == 0 {
+		return nil, fmt.Errorf("missing required argument for --method-from-attribute: --allowed-methods")
+	}
+	switch *invalidMethodAction {
+	case "default", "deadletter":
+	default:
+		return nil, fmt.Errorf("invalid --invalid-method-action %q: must be default or deadletter", *invalidMethodAction)
+	}
+
+	switch *signatureScheme {
+	case "generic", "stripe", "github":
+	default:
+		return nil, fmt.Errorf("invalid --signature-scheme %q: must be generic, stripe or github", *signatureScheme)
+	}
+
+	if *memLimit < 0 {
+		return nil, fmt.Errorf("invalid --mem-limit %d: must not be negative", *memLimit)
+	}
+
+	if *subscriptionHeaderFormat != "short" && *subscriptionHeaderFormat != "full" {
+		return nil, fmt.Errorf("invalid --subscription-header-format %q: must be short or full", *subscriptionHeaderFormat)
+	}
+
+	var jsonAttributes []string
+	for _, name := range strings.Split(*parseJSONAttributes, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			jsonAttributes = append(jsonAttributes, name)
+		}
+	}
+
+	if *lastErrorsSize < 0 {
+		return nil, fmt.Errorf("invalid --last-errors-size %d: must not be negative", *lastErrorsSize)
+	}
+
+	var redactPaths [][]any
+	for _, expression := range redactJSONPaths {
+		path, err := forwarder.ParseRedactPath(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --redact-jsonpath %q: %w", expression, err)
+		}
+		redactPaths = append(redactPaths, path)
+	}
+
+	if *startupJitter < 0 {
+		return nil, fmt.Errorf("invalid --startup-jitter %s: must not be negative", *startupJitter)
+	}
+
+	switch *decodeFormat {
+	case "":
+	case "avro":
+		if (*decodeSchema == "") == (*decodeSchemaRegistryURL == "") {
+			return nil, fmt.Errorf("invalid --decode-format avro: requires one of --decode-schema or --decode-schema-registry-url")
+		}
+	case "protobuf":
+		if *decodeSchema == "" || *decodeMessageType == "" {
+			return nil, fmt.Errorf("invalid --decode-format protobuf: requires --decode-schema and --decode-message-type")
+		}
+		if *decodeSchemaRegistryURL != "" {
+			return nil, fmt.Errorf("invalid --decode-schema-registry-url: only supported with --decode-format avro")
+		}
+	default:
+		return nil, fmt.Errorf("invalid --decode-format %q: must be avro or protobuf", *decodeFormat)
+	}
+	if *decodeSchemaRegistryURL != "" {
+		if u, err := url.Parse(*decodeSchemaRegistryURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid --decode-schema-registry-url %q: must be an http or https URL", *decodeSchemaRegistryURL)
+		}
+	}
+
+	var balanceTargets []forwarder.BalanceTarget
+	for _, value := range balanceURLs {
+		target, err := forwarder.ParseBalanceTarget(value)
+		if err != nil {
+			return nil, err
+		}
+		balanceTargets = append(balanceTargets, target)
+	}
+	if *balanceStrategy != "weighted" && *balanceStrategy != "round-robin" {
+		return nil, fmt.Errorf("invalid --balance-strategy %q: must be weighted or round-robin", *balanceStrategy)
+	}
+
+	var tlsVersion uint16
+	switch *minTLSVersion {
+	case "1.2":
+		tlsVersion = tls.VersionTLS12
+	case "1.3":
+		tlsVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid --min-tls-version %q: must be 1.2 or 1.3", *minTLSVersion)
+	}
+
+	routingKeySource, err := forwarder.ParseKeySource(*keySource)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --key-source: %w", err)
+	}
+
+	if *stateFile != "" && *maxDeliveryAttempts == 0 {
+		return nil, fmt.Errorf("invalid --state-file: requires --max-delivery-attempts")
+	}
+
+	if *http2PingInterval < 0 {
+		return nil, fmt.Errorf("invalid --http2-ping-interval %s: must not be negative", *http2PingInterval)
+	}
+	if *http2PingTimeout <= 0 {
+		return nil, fmt.Errorf("invalid --http2-ping-timeout %s: must be positive", *http2PingTimeout)
+	}
+
+	if *idleKeepAliveInterval < 0 {
+		return nil, fmt.Errorf("invalid --idle-keepalive-interval %s: must not be negative", *idleKeepAliveInterval)
+	}
+
+	if *authServiceAccount != "" && *authAudience == "" {
+		return nil, fmt.Errorf("invalid --auth-service-account: requires --auth-audience")
+	}
+	// Identity tokens are valid for an hour, so a margin that long would mint a token for every request
+	if *tokenRefreshMargin <= 0 || *tokenRefreshMargin >= time.Hour {
+		return nil, fmt.Errorf("invalid --token-refresh-margin %s: must be positive and less than 1h", *tokenRefreshMargin)
+	}
+
+	// Files written by a forwarder would be overwritten or interleaved by the others in the same process
+	if len(subscriptions) > 1 {
+		switch {
+		case *stateFile != "":
+			return nil, fmt.Errorf("invalid --state-file: cannot be shared by the %d subscriptions of --config", len(subscriptions))
+		case *diskBufferDir != "":
+			return nil, fmt.Errorf("invalid --disk-buffer-dir: cannot be shared by the %d subscriptions of --config", len(subscriptions))
+		case *auditLogFile != "":
+			return nil, fmt.Errorf("invalid --audit-log-file: cannot be shared by the %d subscriptions of --config", len(subscriptions))
+		case *captureFile != "":
+			return nil, fmt.Errorf("invalid --capture-file: cannot be shared by the %d subscriptions of --config", len(subscriptions))
+		}
+	}
+
+	if *replayFile != "" && (*configFile != "" || *captureFile != "") {
+		return nil, fmt.Errorf("invalid --replay: cannot be combined with --config or --capture-file")
+	}
+	if len(replayMessageIDs) > 0 && *replayFile == "" {
+		return nil, fmt.Errorf("invalid --replay-message-id: requires --replay")
+	}
+
+	return &forwarder.Config{
+		Project:                   *project,
+		Subscription:              *subscription,
+		URL:                       target,
+		Sinks:                     specs,
+		KafkaBrokers:              brokers,
+		KafkaTopic:                *kafkaTopic,
+		KeepAttributes:            keepAttributes,
+		HeartbeatInterval:         *heartbeatInterval,
+		StartupProbeTimeout:       *startupProbeTimeout,
+		MaxMessageAge:             *maxMessageAge,
+		IncludeSubscriptionID:     *includeSubscriptionID,
+		Format:                    *format,
+		DropOnAttributeName:       dropName,
+		DropOnAttributeValue:      dropValue,
+		MaxInflightBytes:          *maxInflightBytes,
+		SchemaFile:                *schemaFile,
+		DeadLetterTopic:           *deadLetterTopic,
+		AdminAddr:                 *adminAddr,
+		CreateSubscription:        *createSubscription,
+		Topic:                     *topic,
+		AckDeadline:               *ackDeadline,
+		TimeoutFromDeadline:       *timeoutFromDeadline,
+		OrderedWorkers:            *orderedWorkers,
+		AttributesHeader:          *attributesHeader,
+		NackDelay:                 *nackDelay,
+		Chunked:                   *chunked,
+		MaxResponseBytes:          *maxResponseBytes,
+		IncludeSubscriptionLabels: *includeSubscriptionLabels,
+		AlertWebhook:              *alertWebhook,
+		AlertFailureThreshold:     *alertFailureThreshold,
+		Pretty:                    *pretty,
+		DeadlineHeader:            *deadlineHeader,
+		DeadlineHeaderFormat:      *deadlineHeaderFormat,
+		OutputFile:                *outputFile,
+		RotateSize:                *rotateSize,
+		RotateInterval:            *rotateInterval,
+		Accept:                    *accept,
+		RequireResponseField:      responseField,
+		RequireResponseValue:      responseValue,
+		MaxDeliveryAttempts:       *maxDeliveryAttempts,
+		TLSServerName:             *tlsServerName,
+		ExecCommand:               *execCommand,
+		ExecConcurrency:           *execConcurrency,
+		FailoverURL:               *failoverURL,
+		PauseMode:                 *pauseMode,
+		AutoConcurrency:           *autoConcurrency,
+		AutoConcurrencyMax:        *autoConcurrencyMax,
+		MaxConnsPerHost:           *maxConnsPerHost,
+		SampleRate:                *sampleRate,
+		SampleDeterministic:       *sampleDeterministic,
+		DrainTimeout:              *drainTimeout,
+		Headers:                   parsedHeaders,
+		AlwaysIncludeOrderingKey:  *alwaysIncludeOrderingKey,
+		ProxyURL:                  proxy,
+		ProxyHonorNoProxy:         *proxyHonorNoProxy,
+		PerKeyRateLimit:           *perKeyRateLimit,
+		PerKeyBurst:               *perKeyBurst,
+		SkipExistenceCheck:        *skipExistenceCheck,
+		EventHubsConnectionString: *eventHubsConnectionString,
+		EventHubsName:             *eventHubsName,
+		TransformWASM:             *transformWASM,
+		TransformTimeout:          *transformTimeout,
+		DownstreamHangTimeout:     *downstreamHangTimeout,
+		SeekToTime:                seekTime,
+		Compression:               *compression,
+		InstanceID:                *instanceID,
+		ReorderWindow:             *reorderWindow,
+		ReorderBufferSize:         *reorderBufferSize,
+		AuditLogFile:              *auditLogFile,
+		KeepAlivePingInterval:     *keepAlivePingInterval,
+		KeepAlivePingPath:         *keepAlivePingPath,
+		DiskBufferDir:             *diskBufferDir,
+		DiskBufferMaxBytes:        *diskBufferMaxBytes,
+		DiskBufferRetryInterval:   *diskBufferRetryInterval,
+		URLFromAttribute:          *urlFromAttribute,
+		AllowedHosts:              hosts,
+		OnEmptyData:               *onEmptyData,
+		EmptyDataPlaceholder:      *emptyDataPlaceholder,
+		TransformWorkers:          *transformWorkers,
+		SendWorkers:               *sendWorkers,
+		ReadinessCheckInterval:    *readinessCheckInterval,
+		ReadinessFailureThreshold: *readinessFailureThreshold,
+		LogRedeliveries:           *logRedeliveries,
+		CredentialsFile:           *credentialsFile,
+		OrderRetryPolicy:          *orderRetryPolicy,
+		AdminExposeConfig:         *adminExposeConfig,
+		DeliverAfterAttribute:     *deliverAfterAttribute,
+		MaxDelay:                  *maxDelay,
+		GCSCompression:            *gcsCompression,
+		MaxLifetime:               *maxLifetime,
+		ContentDigest:             *contentDigest,
+		MinExtensionPeriod:        *minExtensionPeriod,
+		MaxExtensionPeriod:        *maxExtensionPeriod,
+		CookieJar:                 *cookieJar,
+		LoginURL:                  *loginURL,
+		LoginUsername:             *loginUsername,
+		LoginPasswordFile:         *loginPasswordFile,
+		EnrichmentFile:            *enrichmentFile,
+		EnrichmentAttribute:       *enrichmentAttribute,
+		EnrichmentTarget:          *enrichmentTarget,
+		EnrichmentMissing:         *enrichmentMissing,
+		NormalizeAttributeKeys:    *normalizeAttributeKeys,
+		ExpiryAttribute:           *expiryAttribute,
+		ExpiryMalformed:           *expiryMalformed,
+		LagMonitoringInterval:     *lagMonitoringInterval,
+		SchemaInvalidAction:       *schemaInvalidAction,
+		MinInterval:               *minInterval,
+		CorrelationIDSource:       correlationSource,
+		CorrelationIDHeader:       *correlationIDHeader,
+		CorrelationIDFallback:     *correlationIDFallback,
+		OnPayloadTooLarge:         *onPayloadTooLarge,
+		DrainNackWaiting:          *drainNackWaiting,
+		FlattenAttributes:         *flattenAttributes,
+		FlattenAttributesPrefix:   *flattenAttributesPrefix,
+		RetryMaxDelay:             *retryMaxDelay,
+		MaxRetries:                *maxRetries,
+		RetryBaseDelay:            *retryBaseDelay,
+		RetryTotalTimeout:         *retryTotalTimeout,
+		WorkBufferSize:            *workBufferSize,
+		WorkBufferOverflow:        *workBufferOverflow,
+		MethodFromAttribute:       *methodFromAttribute,
+		AllowedMethods:            methods,
+		InvalidMethodAction:       *invalidMethodAction,
+		SigningSecret:             *signingSecret,
+		SignatureScheme:           *signatureScheme,
+		SignatureHeader:           *signatureHeader,
+		MemoryLimit:               *memLimit,
+		SubscriptionHeader:        *subscriptionHeader,
+		SubscriptionHeaderFormat:  *subscriptionHeaderFormat,
+		ParseJSONAttributes:       jsonAttributes,
+		LastErrorsSize:            *lastErrorsSize,
+		RedactPaths:               redactPaths,
+		RedactMask:                *redactMask,
+		StartupJitter:             *startupJitter,
+		DecodeFormat:              *decodeFormat,
+		DecodeSchema:              *decodeSchema,
+		DecodeMessageType:         *decodeMessageType,
+		DecodeSchemaRegistryURL:   strings.TrimSuffix(*decodeSchemaRegistryURL, "/"),
+		DecodeSchemaIDAttribute:   *decodeSchemaIDAttribute,
+		BalanceTargets:            balanceTargets,
+		BalanceStrategy:           *balanceStrategy,
+		MinTLSVersion:             tlsVersion,
+		KeySource:                 routingKeySource,
+		Version:                   buildVersionOutput(Version),
+		StateFile:                 *stateFile,
+		HTTP2StrictStreamLimit:    *http2StrictStreamLimit,
+		HTTP2PingInterval:         *http2PingInterval,
+		HTTP2PingTimeout:          *http2PingTimeout,
+		IdleKeepAliveInterval:     *idleKeepAliveInterval,
+		IdleKeepAliveMessage:      *idleKeepAliveMessage,
+		AuthAudience:              *authAudience,
+		AuthServiceAccount:        *authServiceAccount,
+		TokenRefreshMargin:        *tokenRefreshMargin,
+		Subscriptions:             subscriptions,
+		CaptureFile:               *captureFile,
+		ReplayFile:                *replayFile,
+		ReplayMessageIDs:          replayMessageIDs,
+	}, nil
 }
 
-// handleShutdown listens for interrupt signals and cancels the context for graceful shutdown
-func handleShutdown(cancelFunc context.CancelFunc) {
+// handleShutdown listens for interrupt signals and cancels the context for graceful shutdown, also shutting
+// down once maxLifetime has elapsed when it is set
+func handleShutdown(cancelFunc context.CancelFunc, maxLifetime time.Duration) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 
-	<-sigChan
-	log.Println("Shutdown signal received. Initiating graceful shutdown...")
+	var lifetime <-chan time.Time
+	if maxLifetime > 0 {
+		timer := time.NewTimer(maxLifetime)
+		defer timer.Stop()
+		lifetime = timer.C
+	}
+
+	select {
+	case <-sigChan:
+		log.Println("Shutdown signal received. Initiating graceful shutdown...")
+	case <-lifetime:
+		log.Printf("Maximum lifetime of %s reached, not a failure. Initiating graceful shutdown for a restart...", maxLifetime)
+	}
 	cancelFunc()
 }
 
@@ -189,33 +1049,47 @@ func main() {
 	// Parse command-line arguments
 	cfg, err := parseFlags()
 	if err != nil {
-		log.Fatalf("Argument parsing error: %v", err)
+		fatalf(exitConfigError, "Argument parsing error: %v", err)
 	}
 
-	log.Printf("Starting Pub/Sub Tester. Project: %s, Subscription: %s, POST URL: %s",
-		cfg.Project, cfg.Subscription, cfg.URL)
+	if cfg.ReplayFile != "" {
+		log.Printf("Starting Pub/Sub Tester. Replaying: %s, POST URL: %s", cfg.ReplayFile, cfg.URL)
+	} else if len(cfg.Subscriptions) > 0 {
+		log.Printf("Starting Pub/Sub Tester. Subscriptions: %d", len(cfg.Subscriptions))
+	} else if len(cfg.Sinks) == 1 && cfg.Sinks[0].Type == "http" && cfg.Sinks[0].Options["url"] == "" {
+		log.Printf("Starting Pub/Sub Tester. Project: %s, Subscription: %s, POST URL: %s",
+			cfg.Project, cfg.Subscription, cfg.URL)
+	} else {
+		log.Printf("Starting Pub/Sub Tester. Project: %s, Subscription: %s, Sinks: %v",
+			cfg.Project, cfg.Subscription, cfg.Sinks)
+	}
+
+	// Make the garbage collector work harder as memory use approaches the limit instead of running out
+	if cfg.MemoryLimit > 0 {
+		debug.SetMemoryLimit(cfg.MemoryLimit)
+	}
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		log.Printf("Memory limit: %d bytes", limit)
+	}
 
 	// Set up context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Handle graceful shutdown in a separate goroutine
-	go handleShutdown(cancel)
+	go handleShutdown(cancel, cfg.MaxLifetime)
 
-	// Initialize Pub/Sub client and subscription
-	client, sub, err := setupPubSubClient(ctx, cfg)
-	if err != nil {
-		log.Fatalf("Pub/Sub setup error: %v", err)
+	// Consume and deliver messages until shutdown
+	switch {
+	case cfg.ReplayFile != "":
+		err = forwarder.Replay(ctx, cfg)
+	case len(cfg.Subscriptions) > 0:
+		err = forwarder.RunSubscriptions(ctx, cfg)
+	default:
+		err = forwarder.Run(ctx, cfg, nil)
 	}
-	defer func() {
-		if err := client.Close(); err != nil {
-			log.Printf("Error closing Pub/Sub client: %v", err)
-		}
-	}()
-
-	// Start consuming messages
-	if err := consumeMessages(ctx, sub, cfg); err != nil {
-		log.Fatalf("Message consumption error: %v", err)
+	if err != nil {
+		fatalf(exitCodeFor(err), "Forwarder error: %v", err)
 	}
 
 	log.Println("Graceful shutdown complete. Exiting application.")
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/UnitVectorY-Labs/pubsubmsgrestforwarder/forwarder"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"invalid config", fmt.Errorf("%w: bad schema", forwarder.ErrInvalidConfig), exitConfigError},
+		{"no credentials", fmt.Errorf("%w: key file: not found", forwarder.ErrNoCredentials), exitAuthError},
+		{"setup permission denied", fmt.Errorf("%w: %w", forwarder.ErrPubSubSetup, status.Error(codes.PermissionDenied, "denied")), exitAuthError},
+		{"setup unauthenticated", fmt.Errorf("%w: %w", forwarder.ErrPubSubSetup, status.Error(codes.Unauthenticated, "expired")), exitAuthError},
+		{"downstream auth", fmt.Errorf("%w: no token", forwarder.ErrDownstreamAuth), exitAuthError},
+		{"failed seek", fmt.Errorf("%w: failed to seek subscription: %w", forwarder.ErrPubSubSetup, status.Error(codes.InvalidArgument, "bad time")), exitPubSubSetupError},
+		{"failed creation", fmt.Errorf("%w: %w", forwarder.ErrPubSubSetup, status.Error(codes.NotFound, "topic not found")), exitPubSubSetupError},
+		{"subscription not found", fmt.Errorf("%w: orders", forwarder.ErrSubscriptionNotFound), exitSubscriptionNotFound},
+		{"downstream unreachable", forwarder.ErrDownstreamUnreachable, exitDownstreamUnreachable},
+		{"receive", fmt.Errorf("%w: stream closed", forwarder.ErrReceive), exitReceiveError},
+		{"unexpected", errors.New("boom"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeFor(tt.err); got != tt.want {
+				t.Errorf("exitCodeFor(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}